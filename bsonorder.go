@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// canonicalType 返回 BSON 类型在 MongoDB 排序规则中的次序, 数值类型之间、字符串和 Symbol 之间可以互相比较
+// 参考 https://www.mongodb.com/docs/manual/reference/bson-type-comparison-order/
+func canonicalType(t bsontype.Type) int {
+	switch t {
+	case bson.TypeMinKey:
+		return -1
+	case bson.TypeUndefined:
+		return 0
+	case bson.TypeNull:
+		return 5
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return 10
+	case bson.TypeString, bson.TypeSymbol:
+		return 15
+	case bson.TypeEmbeddedDocument:
+		return 20
+	case bson.TypeArray:
+		return 25
+	case bson.TypeBinary:
+		return 30
+	case bson.TypeObjectID:
+		return 35
+	case bson.TypeBoolean:
+		return 40
+	case bson.TypeDateTime:
+		return 45
+	case bson.TypeTimestamp:
+		return 47
+	case bson.TypeRegex:
+		return 50
+	case bson.TypeDBPointer:
+		return 55
+	case bson.TypeJavaScript:
+		return 60
+	case bson.TypeCodeWithScope:
+		return 65
+	case bson.TypeMaxKey:
+		return 127
+	}
+	return 100
+}
+
+// compareValues 按 MongoDB 的排序规则比较两个 BSON 值, 用于和服务端按 _id 排序的结果保持一致
+// 字符串按字节比较, 即 simple collation
+func compareValues(a, b bson.RawValue) int {
+	if c := compareInt(canonicalType(a.Type), canonicalType(b.Type)); c != 0 {
+		return c
+	}
+
+	switch a.Type {
+	case bson.TypeMinKey, bson.TypeMaxKey, bson.TypeNull, bson.TypeUndefined:
+		return 0
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return compareNumbers(a, b)
+	case bson.TypeString, bson.TypeSymbol:
+		return bytes.Compare([]byte(stringValue(a)), []byte(stringValue(b)))
+	case bson.TypeEmbeddedDocument:
+		return compareDocuments(a.Document(), b.Document())
+	case bson.TypeArray:
+		return compareDocuments(bson.Raw(a.Array()), bson.Raw(b.Array()))
+	case bson.TypeBinary:
+		aSubtype, aData := a.Binary()
+		bSubtype, bData := b.Binary()
+		if c := compareInt(len(aData), len(bData)); c != 0 {
+			return c
+		}
+		if c := compareInt(int(aSubtype), int(bSubtype)); c != 0 {
+			return c
+		}
+		return bytes.Compare(aData, bData)
+	case bson.TypeBoolean:
+		return compareInt(boolToInt(a.Boolean()), boolToInt(b.Boolean()))
+	case bson.TypeDateTime:
+		return compareInt64(a.DateTime(), b.DateTime())
+	case bson.TypeTimestamp:
+		aT, aI := a.Timestamp()
+		bT, bI := b.Timestamp()
+		if aT != bT {
+			return compareInt64(int64(aT), int64(bT))
+		}
+		return compareInt64(int64(aI), int64(bI))
+	case bson.TypeRegex:
+		aPattern, aOptions := a.Regex()
+		bPattern, bOptions := b.Regex()
+		if c := bytes.Compare([]byte(aPattern), []byte(bPattern)); c != 0 {
+			return c
+		}
+		return bytes.Compare([]byte(aOptions), []byte(bOptions))
+	}
+	// ObjectId 以及其他不常见的类型直接按字节比较
+	return bytes.Compare(a.Value, b.Value)
+}
+
+// compareDocuments 逐个字段比较, 先比较字段值的类型次序, 再比较字段名, 最后比较字段值
+func compareDocuments(a, b bson.Raw) int {
+	aElems, _ := a.Elements()
+	bElems, _ := b.Elements()
+	for i := 0; i < len(aElems) && i < len(bElems); i++ {
+		aValue, bValue := aElems[i].Value(), bElems[i].Value()
+		if c := compareInt(canonicalType(aValue.Type), canonicalType(bValue.Type)); c != 0 {
+			return c
+		}
+		if c := bytes.Compare([]byte(aElems[i].Key()), []byte(bElems[i].Key())); c != 0 {
+			return c
+		}
+		if c := compareValues(aValue, bValue); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(aElems), len(bElems))
+}
+
+// compareNumbers 比较不同类型的数值, NaN 小于所有数值
+func compareNumbers(a, b bson.RawValue) int {
+	aFloat, aNaN := numberToBigFloat(a)
+	bFloat, bNaN := numberToBigFloat(b)
+	switch {
+	case aNaN && bNaN:
+		return 0
+	case aNaN:
+		return -1
+	case bNaN:
+		return 1
+	}
+	return aFloat.Cmp(bFloat)
+}
+
+// numberToBigFloat 将数值转换为 big.Float, 以便 int64 和 double 之间可以精确比较
+func numberToBigFloat(v bson.RawValue) (*big.Float, bool) {
+	switch v.Type {
+	case bson.TypeInt32:
+		return new(big.Float).SetInt64(int64(v.Int32())), false
+	case bson.TypeInt64:
+		return new(big.Float).SetInt64(v.Int64()), false
+	case bson.TypeDouble:
+		f := v.Double()
+		if math.IsNaN(f) {
+			return nil, true
+		}
+		return new(big.Float).SetFloat64(f), false
+	case bson.TypeDecimal128:
+		d := v.Decimal128()
+		if d.IsNaN() {
+			return nil, true
+		}
+		if d.IsInf() != 0 {
+			return new(big.Float).SetInf(d.IsInf() < 0), false
+		}
+		coefficient, exp, err := d.BigInt()
+		if err != nil {
+			return nil, true
+		}
+		f := new(big.Float).SetInt(coefficient)
+		scale := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(exp))), nil))
+		if exp < 0 {
+			return f.Quo(f, scale), false
+		}
+		return f.Mul(f, scale), false
+	}
+	return nil, true
+}
+
+func stringValue(v bson.RawValue) string {
+	if v.Type == bson.TypeSymbol {
+		return v.Symbol()
+	}
+	return v.StringValue()
+}
+
+func compareInt(a, b int) int {
+	return compareInt64(int64(a), int64(b))
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func rawValue(t *testing.T, v interface{}) bson.RawValue {
+	t.Helper()
+	typ, data, err := bson.MarshalValue(v)
+	if err != nil {
+		t.Fatalf("MarshalValue(%v): %v", v, err)
+	}
+	return bson.RawValue{Type: typ, Value: data}
+}
+
+func decimal(t *testing.T, s string) primitive.Decimal128 {
+	t.Helper()
+	d, err := primitive.ParseDecimal128(s)
+	if err != nil {
+		t.Fatalf("ParseDecimal128(%q): %v", s, err)
+	}
+	return d
+}
+
+func oid(t *testing.T, s string) primitive.ObjectID {
+	t.Helper()
+	id, err := primitive.ObjectIDFromHex(s)
+	if err != nil {
+		t.Fatalf("ObjectIDFromHex(%q): %v", s, err)
+	}
+	return id
+}
+
+// TestCompareValuesOrder 按服务端的排序结果列出一组值, 同一组内的值相等, 组之间严格递增
+func TestCompareValuesOrder(t *testing.T) {
+	groups := []struct {
+		name   string
+		values []interface{}
+	}{
+		{"minKey", []interface{}{primitive.MinKey{}}},
+		{"null", []interface{}{primitive.Null{}}},
+		{"NaN", []interface{}{math.NaN(), decimal(t, "NaN")}},
+		{"-Inf", []interface{}{math.Inf(-1), decimal(t, "-Infinity")}},
+		{"minInt64", []interface{}{int64(math.MinInt64)}},
+		{"-1.5", []interface{}{-1.5, decimal(t, "-1.50")}},
+		{"-1", []interface{}{int32(-1), int64(-1), -1.0, decimal(t, "-1")}},
+		{"-0.5", []interface{}{decimal(t, "-0.5"), -0.5}},
+		{"0", []interface{}{int32(0), math.Copysign(0, -1), 0.0, decimal(t, "0.000")}},
+		{"decimal 0.1", []interface{}{decimal(t, "0.1")}},
+		// double 0.1 实际上略大于 0.1
+		{"double 0.1", []interface{}{0.1}},
+		{"1", []interface{}{int32(1), int64(1), 1.0, decimal(t, "1E0"), decimal(t, "10E-1")}},
+		{"2^53", []interface{}{int64(1 << 53), float64(1 << 53)}},
+		{"2^53+1", []interface{}{int64(1<<53 + 1)}},
+		{"maxInt64", []interface{}{int64(math.MaxInt64)}},
+		{"2^63", []interface{}{float64(1 << 63), decimal(t, "9223372036854775808")}},
+		{"+Inf", []interface{}{math.Inf(1), decimal(t, "Infinity")}},
+		{"empty string", []interface{}{""}},
+		{"a", []interface{}{"a", primitive.Symbol("a")}},
+		{"ab", []interface{}{"ab"}},
+		{"b", []interface{}{"b"}},
+		{"empty document", []interface{}{bson.D{}}},
+		{"{a:1}", []interface{}{bson.D{{Key: "a", Value: int32(1)}}, bson.D{{Key: "a", Value: 1.0}}}},
+		{"{a:1,b:1}", []interface{}{bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}}}},
+		{"{a:2}", []interface{}{bson.D{{Key: "a", Value: 2}}}},
+		// 先比较字段值的类型再比较字段名, 所以 {b:1} 小于 {a:"x"}
+		{"{b:1}", []interface{}{bson.D{{Key: "b", Value: 1}}}},
+		{"{a:'x'}", []interface{}{bson.D{{Key: "a", Value: "x"}}}},
+		{"empty array", []interface{}{bson.A{}}},
+		{"[1]", []interface{}{bson.A{1}}},
+		{"[1,2]", []interface{}{bson.A{1, 2}}},
+		{"[2]", []interface{}{bson.A{2}}},
+		// binData 先比较长度, 再比较 subtype, 最后比较内容
+		{"binary len 1", []interface{}{primitive.Binary{Subtype: 0x80, Data: []byte{0xff}}}},
+		{"binary len 2 subtype 0 00 01", []interface{}{primitive.Binary{Subtype: 0, Data: []byte{0x00, 0x01}}}},
+		{"binary len 2 subtype 0 01 00", []interface{}{primitive.Binary{Subtype: 0, Data: []byte{0x01, 0x00}}}},
+		{"binary len 2 subtype 4", []interface{}{primitive.Binary{Subtype: 4, Data: []byte{0x00, 0x00}}}},
+		{"objectId 1", []interface{}{oid(t, "000000000000000000000001")}},
+		{"objectId 2", []interface{}{oid(t, "65a000000000000000000000")}},
+		{"false", []interface{}{false}},
+		{"true", []interface{}{true}},
+		{"date before epoch", []interface{}{primitive.DateTime(-1)}},
+		{"date epoch", []interface{}{primitive.DateTime(0)}},
+		{"date after epoch", []interface{}{primitive.DateTime(1)}},
+		{"timestamp 1,2", []interface{}{primitive.Timestamp{T: 1, I: 2}}},
+		{"timestamp 2,1", []interface{}{primitive.Timestamp{T: 2, I: 1}}},
+		{"timestamp max", []interface{}{primitive.Timestamp{T: math.MaxUint32, I: 0}}},
+		{"regex a", []interface{}{primitive.Regex{Pattern: "a"}}},
+		{"regex a/i", []interface{}{primitive.Regex{Pattern: "a", Options: "i"}}},
+		{"regex b", []interface{}{primitive.Regex{Pattern: "b"}}},
+		{"maxKey", []interface{}{primitive.MaxKey{}}},
+	}
+
+	for i, gi := range groups {
+		for j, gj := range groups {
+			want := compareInt(i, j)
+			for _, a := range gi.values {
+				for _, b := range gj.values {
+					if got := compareValues(rawValue(t, a), rawValue(t, b)); got != want {
+						t.Errorf("compareValues(%s %v, %s %v) = %d, want %d", gi.name, a, gj.name, b, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCompareDocuments(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b bson.D
+		want int
+	}{
+		{"equal", bson.D{{Key: "_id", Value: 1}, {Key: "x", Value: "a"}}, bson.D{{Key: "_id", Value: 1.0}, {Key: "x", Value: "a"}}, 0},
+		{"prefix is smaller", bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "a", Value: 1}, {Key: "b", Value: primitive.MinKey{}}}, -1},
+		{"field name", bson.D{{Key: "a", Value: 1}}, bson.D{{Key: "b", Value: 1}}, -1},
+		{"type before field name", bson.D{{Key: "b", Value: primitive.Null{}}}, bson.D{{Key: "a", Value: 1}}, -1},
+		{"field order matters", bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 2}}, bson.D{{Key: "b", Value: 2}, {Key: "a", Value: 1}}, -1},
+		{"nested", bson.D{{Key: "a", Value: bson.D{{Key: "x", Value: 1}}}}, bson.D{{Key: "a", Value: bson.D{{Key: "x", Value: 2}}}}, -1},
+	}
+	for _, tt := range tests {
+		a, err := bson.Marshal(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := bson.Marshal(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := compareDocuments(a, b); got != tt.want {
+			t.Errorf("%s: compareDocuments = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := compareDocuments(b, a); got != -tt.want {
+			t.Errorf("%s: reversed compareDocuments = %d, want %d", tt.name, got, -tt.want)
+		}
+	}
+}
@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go build mongocheck.go
\ No newline at end of file
+go build
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// changeTracker 在检查期间监听源集合的 change stream, 记录被修改过的文档的 _id
+type changeTracker struct {
+	name   string
+	mu     sync.Mutex
+	ids    map[string]bool
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// watchCollection 开始监听集合的修改, 需要副本集或者分片集群
+func watchCollection(coll *mongo.Collection) (*changeTracker, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// 只需要 documentKey, 不需要返回修改的内容
+	pipeline := mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{{Key: "documentKey", Value: 1}}}},
+	}
+	stream, err := coll.Watch(ctx, pipeline)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("源集合 %s 开启 change stream 失败, 需要副本集或者分片集群: %v", collNS(coll), err)
+	}
+
+	t := &changeTracker{name: collNS(coll), ids: make(map[string]bool), cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		defer stream.Close(context.Background())
+		for stream.Next(ctx) {
+			id := stream.Current.Lookup("documentKey", "_id")
+			if id.Type == 0 {
+				// drop、rename 等事件没有 documentKey
+				continue
+			}
+			t.mu.Lock()
+			t.ids[lookupKey(id)] = true
+			t.mu.Unlock()
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			log.Printf("源集合 %s 的 change stream 中断, 之后的修改无法识别: %v", t.name, err)
+		}
+	}()
+	log.Printf("开始监听源集合 %s 的修改", collNS(coll))
+	return t, nil
+}
+
+// touched 返回检查期间文档是否被修改过
+func (t *changeTracker) touched(id bson.RawValue) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ids[lookupKey(id)]
+}
+
+// stop 停止监听
+func (t *changeTracker) stop() {
+	t.cancel()
+	<-t.done
+	t.mu.Lock()
+	log.Printf("停止监听源集合 %s 的修改, 检查期间被修改的文档 %d 条", t.name, len(t.ids))
+	t.mu.Unlock()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sampleDirection 表示抽样方向
+type sampleDirection int
+
+const (
+	forward sampleDirection = iota // 从源集群抽样, 到目标集群查询对应的文档
+	reverse                        // 从目标集群抽样, 到源集群查询对应的文档, 用于发现目标集群多余的数据
+)
+
+func (d sampleDirection) String() string {
+	if d == reverse {
+		return "目标集群->源集群"
+	}
+	return "源集群->目标集群"
+}
+
+// checkTask 描述一次抽样比对: 从 srcColl 抽样, 到 dstColl 查询对应的文档
+// 反向抽样时 srcColl 是目标集群的集合, dstColl 是源集群的集合
+type checkTask struct {
+	srcColl *mongo.Collection
+	dstColl *mongo.Collection
+	dir     sampleDirection
+	cmp     *comparator
+	result  *collResult // 两个方向的抽样共享同一个集合的结果
+}
+
+// srcSide 返回抽样一侧的集群名称, 用于日志
+func (t *checkTask) srcSide() string {
+	if t.dir == reverse {
+		return "目标"
+	}
+	return "源"
+}
+
+// dstSide 返回查询一侧的集群名称, 用于日志
+func (t *checkTask) dstSide() string {
+	if t.dir == reverse {
+		return "源"
+	}
+	return "目标"
+}
+
+// notFoundKind 返回查询一侧缺少数据时的类别
+func (t *checkTask) notFoundKind() findingKind {
+	if t.dir == reverse {
+		return findingExtra
+	}
+	return findingMissing
+}
+
+// notFoundMessage 描述查询一侧缺少某条数据: 正向抽样时是目标集群缺失数据, 反向抽样时是目标集群多余数据
+func (t *checkTask) notFoundMessage(id bson.RawValue) string {
+	if t.dir == reverse {
+		return fmt.Sprintf("目标集合 %s 存在多余的数据, 源集合 %s 没有对应的数据, _id:%v", collNS(t.srcColl), collNS(t.dstColl), id.String())
+	}
+	return fmt.Sprintf("目标集合 %s 没有对应的数据, _id:%v", collNS(t.dstColl), id.String())
+}
+
+// docChecker 缓存抽样得到的源文档, 攒够一批后使用一次 {_id: {$in: [...]}} 查询取回目标文档, 再按 _id 在内存中比对
+// 避免每条源文档都到目标集群执行一次 FindOne
+type docChecker struct {
+	task  *checkTask
+	total int64 // 预期比对的文档数, 用于打印进度, 为 0 时不打印
+
+	batch   []bson.Raw
+	success int64
+	progres int64
+}
+
+func newDocChecker(task *checkTask, total int64) *docChecker {
+	return &docChecker{
+		task:  task,
+		total: total,
+		batch: make([]bson.Raw, 0, *batchSize),
+	}
+}
+
+// add 加入一条源文档, 缓存的文档数达到 batchSize 时触发一次批量比对
+// 游标的 Current 在下次 Next 之后会失效, 所以这里会拷贝一份
+func (c *docChecker) add(doc bson.Raw) error {
+	c.batch = append(c.batch, append(bson.Raw(nil), doc...))
+	if len(c.batch) >= *batchSize {
+		return c.flush()
+	}
+	return nil
+}
+
+// flush 比对缓存中的所有源文档
+func (c *docChecker) flush() error {
+	if len(c.batch) == 0 {
+		return nil
+	}
+
+	task := c.task
+	ids := make(bson.A, 0, len(c.batch))
+	for _, doc := range c.batch {
+		ids = append(ids, doc.Lookup("_id"))
+	}
+	findOptions := options.FindOptions{
+		Projection: task.cmp.findProjection(),
+	}
+	ctx, end := readContext(context.Background(), task.dstColl)
+	defer end()
+	dstCursor, err := task.dstColl.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, &findOptions)
+	if err != nil {
+		return c.lookupFailed(err)
+	}
+	dstDocs := make(map[string]bson.Raw, len(c.batch))
+	for dstCursor.Next(ctx) {
+		dstDocs[lookupKey(dstCursor.Current.Lookup("_id"))] = append(bson.Raw(nil), dstCursor.Current...)
+	}
+	err = dstCursor.Err()
+	dstCursor.Close(ctx)
+	if err != nil {
+		return c.lookupFailed(err)
+	}
+
+	for _, srcDoc := range c.batch {
+		id := srcDoc.Lookup("_id")
+		dstDoc, ok := dstDocs[lookupKey(id)]
+		if !ok {
+			kind := task.notFoundKind()
+			err := task.result.record(finding{kind: kind, id: id})
+			if !*continueNotExist && !*collectAll && !task.result.tolerates(kind) {
+				return errors.New(task.notFoundMessage(id))
+			}
+			log.Print(task.notFoundMessage(id))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// 两个方向都检查时, 两边都存在的文档由正向抽样比对内容, 反向抽样只查找目标集群多余的文档, 避免同一条文档记录两次
+		compare := task.dir == forward || *direction != "both"
+		if compare && !task.cmp.equal(srcDoc, dstDoc) {
+			if task.dir == reverse {
+				srcDoc, dstDoc = dstDoc, srcDoc
+			}
+			err := task.result.recordMismatch(id, task.cmp.reportMismatch(id, srcDoc, dstDoc))
+			if !*collectAll && !task.result.tolerates(findingDiffer) {
+				return fmt.Errorf("源集合 %s 数据不一致, _id:%v", collNS(task.srcColl), id.String())
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if compare {
+			task.result.same.Add(1)
+		}
+		c.success++
+		if c.total > 0 && (c.success*100/c.total) > c.progres {
+			c.progres = c.success * 100 / c.total
+			log.Printf("集合 %s 抽样数据 %d 条, 方向:%s, 进度: %d%%", collNS(task.srcColl), c.success, task.dir, c.progres)
+		}
+	}
+	c.batch = c.batch[:0]
+	return nil
+}
+
+// lookupFailed 处理批量查询失败, collectAll 模式下将这一批文档记为查询失败后继续检查, 否则返回错误
+func (c *docChecker) lookupFailed(err error) error {
+	task := c.task
+	err = fmt.Errorf("批量获取%s集合 %s 数据失败, 批次大小:%d, err: %v", task.dstSide(), collNS(task.dstColl), len(c.batch), err)
+	if !*collectAll || srcSnapshot.fallback(err) {
+		// 快照历史不足时需要回退为普通读重新检查, 不能记为查询失败
+		return err
+	}
+	log.Print(err)
+	for _, doc := range c.batch {
+		if err := task.result.record(finding{kind: findingLookupError, id: doc.Lookup("_id")}); err != nil {
+			return err
+		}
+	}
+	c.batch = c.batch[:0]
+	return nil
+}
+
+// idKey 将 _id 转换成可以作为 map key 的字符串, 类型不同的 _id 不会被当成同一个
+func idKey(id bson.RawValue) string {
+	return string(append([]byte{byte(id.Type)}, id.Value...))
+}
+
+// lookupKey 将 _id 转换成按 _id 查询结果的 map key, 和 $in 的匹配规则一致, 数值相等的 int/long/double/decimal 是同一个 key
+// 迁移时 _id 的数值类型可能发生变化, 例如 int 变成 long, 这时仍然能找到对应的文档, 再按比对规则判断内容是否一致
+// 数值按精确值转换成分数, 所以 double 0.1 和 decimal 0.1 这类服务端认为不相等的值不是同一个 key
+func lookupKey(id bson.RawValue) string {
+	r := new(big.Rat)
+	switch id.Type {
+	case bson.TypeInt32:
+		r.SetInt64(int64(id.Int32()))
+	case bson.TypeInt64:
+		r.SetInt64(id.Int64())
+	case bson.TypeDouble:
+		f := id.Double()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "n" + strconv.FormatFloat(f, 'g', -1, 64)
+		}
+		r.SetFloat64(f)
+	case bson.TypeDecimal128:
+		d := id.Decimal128()
+		switch {
+		case d.IsNaN():
+			return "n" + strconv.FormatFloat(math.NaN(), 'g', -1, 64)
+		case d.IsInf() != 0:
+			return "n" + strconv.FormatFloat(math.Inf(d.IsInf()), 'g', -1, 64)
+		}
+		n, exp, err := d.BigInt()
+		if err != nil {
+			return idKey(id)
+		}
+		scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(absInt(exp))), nil))
+		r.SetInt(n)
+		if exp >= 0 {
+			r.Mul(r, scale)
+		} else {
+			r.Quo(r, scale)
+		}
+	default:
+		return idKey(id)
+	}
+	return "n" + r.RatString()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestLookupKey 按 $in 的匹配规则分组, 同一组内的 _id 是同一个 key, 不同组的 _id 不是同一个 key
+func TestLookupKey(t *testing.T) {
+	groups := []struct {
+		name string
+		ids  []interface{}
+	}{
+		{"1", []interface{}{int32(1), int64(1), 1.0, decimal(t, "1"), decimal(t, "1.000"), decimal(t, "0.1E1")}},
+		{"0", []interface{}{int32(0), int64(0), 0.0, math.Copysign(0, -1), decimal(t, "0"), decimal(t, "-0.00")}},
+		{"-7", []interface{}{int32(-7), int64(-7), -7.0, decimal(t, "-7")}},
+		{"1.5", []interface{}{1.5, decimal(t, "1.5"), decimal(t, "15E-1")}},
+		{"decimal 0.1", []interface{}{decimal(t, "0.1")}},
+		// double 0.1 不是精确的 0.1, 服务端认为和 decimal 0.1 不相等
+		{"double 0.1", []interface{}{0.1}},
+		{"2^53", []interface{}{int64(1 << 53), float64(1 << 53), decimal(t, "9007199254740992")}},
+		{"2^53+1", []interface{}{int64(1<<53 + 1), decimal(t, "9007199254740993")}},
+		{"maxInt64", []interface{}{int64(math.MaxInt64)}},
+		{"2^63", []interface{}{float64(1 << 63), decimal(t, "9.223372036854775808E18")}},
+		{"1E20", []interface{}{1e20, decimal(t, "1E20")}},
+		{"NaN", []interface{}{math.NaN(), decimal(t, "NaN")}},
+		{"+Inf", []interface{}{math.Inf(1), decimal(t, "Infinity")}},
+		{"-Inf", []interface{}{math.Inf(-1), decimal(t, "-Infinity")}},
+		{"string 1", []interface{}{"1"}},
+		{"string n1", []interface{}{"n1"}},
+		{"objectId", []interface{}{oid(t, "65a000000000000000000001")}},
+		{"binary", []interface{}{primitive.Binary{Subtype: 4, Data: []byte("0123456789abcdef")}}},
+	}
+	keys := make(map[string]string)
+	for _, g := range groups {
+		first := lookupKey(rawValue(t, g.ids[0]))
+		for _, id := range g.ids[1:] {
+			if key := lookupKey(rawValue(t, id)); key != first {
+				t.Errorf("%s: lookupKey(%T %v) = %q, want %q", g.name, id, id, key, first)
+			}
+		}
+		if other, ok := keys[first]; ok {
+			t.Errorf("%s and %s have the same lookupKey %q", g.name, other, first)
+		}
+		keys[first] = g.name
+	}
+}
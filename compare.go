@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// normalizeRule 是比对时可以开启的类型规范化规则, 按位组合
+type normalizeRule int
+
+const (
+	ruleNumeric      normalizeRule = 1 << iota // int32/int64/double/decimal128 数值相等即认为一致
+	ruleFloatEpsilon                           // 数值之差不超过 floatEpsilon 即认为一致
+	ruleUUID                                   // 旧版 UUID(subtype 3) 和新版 UUID(subtype 4) 表示同一个值即认为一致
+	ruleDate                                   // 时间之差小于 datePrecision 即认为一致
+	ruleSetArray                               // setArrays 指定的数组元素相同但顺序不同即认为一致
+	ruleCount        = iota
+)
+
+var ruleNames = [ruleCount]string{"numeric", "floatEpsilon", "uuid", "date", "setArrays"}
+
+func (r normalizeRule) String() string {
+	names := make([]string, 0, ruleCount)
+	for i, name := range ruleNames {
+		if r&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// comparator 决定两条文档是否一致, 默认按原始 BSON 字节严格比较
+type comparator struct {
+	ns        string // 源集合的 "库名.集合名"
+	unordered bool   // 嵌入文档按无序的 map 比较, 数组仍然按位置比较
+
+	fields     *fieldFilter   // 比对前对两边文档的字段过滤
+	projection bson.D         // 下推到服务端的投影, 为 nil 时返回完整文档
+	filter     bson.D         // 只检查满足该条件的文档, 为 nil 时检查所有文档
+	setArrays  []fieldPattern // 元素按多重集合比较, 忽略顺序的数组
+
+	numeric            bool
+	floatEpsilon       float64
+	uuid               bool
+	uuidRepresentation string
+	datePrecision      time.Duration
+
+	// 每个规则使得多少条文档从不一致变成一致
+	ruleUsed [ruleCount]atomic.Int64
+}
+
+// newComparator 根据命令行参数和集合的配置创建 comparator
+func newComparator(coll *mongo.Collection, conf collConfig) *comparator {
+	c := &comparator{
+		ns:                 collNS(coll),
+		unordered:          *compareMode == "unordered",
+		fields:             newFieldFilter(conf.IgnoreFields, conf.OnlyFields),
+		setArrays:          parseFieldPatterns(conf.SetArrays),
+		floatEpsilon:       *floatEpsilon,
+		uuidRepresentation: *uuidRepresentation,
+	}
+	for _, rule := range strings.Split(*normalize, ",") {
+		switch strings.TrimSpace(rule) {
+		case "numeric":
+			c.numeric = true
+		case "uuid":
+			c.uuid = true
+		case "date":
+			c.datePrecision = *datePrecision
+		}
+	}
+	c.projection = c.fields.projection()
+	if !c.fields.empty() {
+		log.Printf("集合 %s 比对时忽略字段:%v, 只比对字段:%v, 服务端投影:%v", c.ns, conf.IgnoreFields, conf.OnlyFields, c.projection)
+	}
+	c.filter = conf.filter
+	if c.filter != nil {
+		log.Printf("集合 %s 只检查满足条件 %s 的文档", c.ns, conf.Filter)
+	}
+	return c
+}
+
+// match 返回在 cond 的基础上加上 filter 的查询条件
+func (c *comparator) match(cond bson.M) interface{} {
+	if c.filter == nil {
+		return cond
+	}
+	if len(cond) == 0 {
+		return c.filter
+	}
+	return bson.D{{Key: "$and", Value: bson.A{c.filter, cond}}}
+}
+
+// findProjection 返回 Find 使用的投影, 没有投影时返回 nil 接口, 避免发送空的投影
+func (c *comparator) findProjection() interface{} {
+	if c.projection == nil {
+		return nil
+	}
+	return c.projection
+}
+
+// relaxed 返回是否需要逐个字段比较, 否则字节不同的文档一定不一致
+func (c *comparator) relaxed() bool {
+	return c.unordered || c.numeric || c.floatEpsilon > 0 || c.uuid || c.datePrecision > 0 || len(c.setArrays) > 0
+}
+
+// equal 比较两条文档, 字节完全相同时不需要再逐个字段比较, 否则先按 fields 过滤两边的字段再比较
+// 依赖规范化规则才一致的文档会按规则计数, 每个规则第一次生效时打印一条示例
+func (c *comparator) equal(a bson.Raw, b bson.Raw) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if !c.fields.empty() {
+		a, b = c.fields.apply(a), c.fields.apply(b)
+		if bytes.Equal(a, b) {
+			return true
+		}
+	}
+	if !c.relaxed() {
+		return false
+	}
+	var used normalizeRule
+	if !c.equalDocuments(a, b, nil, &used) {
+		return false
+	}
+	for i := 0; i < ruleCount; i++ {
+		if used&(1<<i) != 0 && c.ruleUsed[i].Add(1) == 1 {
+			log.Printf("集合 %s 存在按规则 %s 规范化后才一致的文档, 例如 _id:%v", c.ns, ruleNames[i], a.Lookup("_id").String())
+		}
+	}
+	return true
+}
+
+// logRuleUsage 打印每个规范化规则生效的文档数
+func (c *comparator) logRuleUsage() {
+	for i, name := range ruleNames {
+		if n := c.ruleUsed[i].Load(); n > 0 {
+			log.Printf("集合 %s 按规则 %s 规范化后一致的文档数:%d", c.ns, name, n)
+		}
+	}
+}
+
+// childPath 返回子字段的路径, 只有 setArrays 需要按路径匹配, 没有配置时不分配内存
+func (c *comparator) childPath(path []pathSeg, name string, index bool) []pathSeg {
+	if len(c.setArrays) == 0 {
+		return nil
+	}
+	return append(path[:len(path):len(path)], pathSeg{name: name, index: index})
+}
+
+// isSetArray 返回 path 指向的数组是否按多重集合比较
+func (c *comparator) isSetArray(path []pathSeg) bool {
+	for _, p := range c.setArrays {
+		if p.matchExact(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalDocuments 逐个字段比较两个文档, path 是文档自身的路径
+func (c *comparator) equalDocuments(a bson.Raw, b bson.Raw, path []pathSeg, used *normalizeRule) bool {
+	aElems, err := a.Elements()
+	if err != nil {
+		return false
+	}
+	bElems, err := b.Elements()
+	if err != nil || len(aElems) != len(bElems) {
+		return false
+	}
+
+	if !c.unordered {
+		for i := range aElems {
+			key := aElems[i].Key()
+			if key != bElems[i].Key() || !c.equalValues(aElems[i].Value(), bElems[i].Value(), c.childPath(path, key, false), used) {
+				return false
+			}
+		}
+		return true
+	}
+
+	bValues := make(map[string]bson.RawValue, len(bElems))
+	for _, elem := range bElems {
+		bValues[elem.Key()] = elem.Value()
+	}
+	for _, elem := range aElems {
+		bValue, ok := bValues[elem.Key()]
+		if !ok || !c.equalValues(elem.Value(), bValue, c.childPath(path, elem.Key(), false), used) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalArrays 按位置比较两个数组, setArrays 指定的数组按位置比较不一致时再按多重集合比较
+func (c *comparator) equalArrays(a bson.Raw, b bson.Raw, path []pathSeg, used *normalizeRule) bool {
+	aValues, err := a.Values()
+	if err != nil {
+		return false
+	}
+	bValues, err := b.Values()
+	if err != nil || len(aValues) != len(bValues) {
+		return false
+	}
+
+	var positional normalizeRule
+	equal := true
+	for i := range aValues {
+		if !c.equalValues(aValues[i], bValues[i], c.childPath(path, strconv.Itoa(i), true), &positional) {
+			equal = false
+			break
+		}
+	}
+	if equal {
+		*used |= positional
+		return true
+	}
+	if !c.isSetArray(path) {
+		return false
+	}
+	if c.equalMultisets(aValues, bValues, path, used) {
+		*used |= ruleSetArray
+		return true
+	}
+	return false
+}
+
+// equalMultisets 忽略顺序比较两个数组的元素, 每个元素只能匹配一次
+// 先按字节完全相同的元素配对, 剩下的元素再两两按规则比较
+func (c *comparator) equalMultisets(aValues []bson.RawValue, bValues []bson.RawValue, path []pathSeg, used *normalizeRule) bool {
+	pending := make(map[string]int, len(bValues))
+	for _, v := range bValues {
+		pending[idKey(v)]++
+	}
+	var aRest []bson.RawValue
+	for _, v := range aValues {
+		if key := idKey(v); pending[key] > 0 {
+			pending[key]--
+		} else {
+			aRest = append(aRest, v)
+		}
+	}
+	if len(aRest) == 0 {
+		return true
+	}
+
+	var bRest []bson.RawValue
+	for _, v := range bValues {
+		if key := idKey(v); pending[key] > 0 {
+			pending[key]--
+			bRest = append(bRest, v)
+		}
+	}
+	matched := make([]bool, len(bRest))
+	elemPath := c.childPath(path, "*", true)
+	for _, a := range aRest {
+		found := false
+		for j, b := range bRest {
+			if !matched[j] && c.equalValues(a, b, elemPath, used) {
+				matched[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// equalValues 比较两个字段值, 嵌入文档和数组递归比较, 其他类型先按字节比较, 不一致时再尝试开启的规范化规则
+func (c *comparator) equalValues(a bson.RawValue, b bson.RawValue, path []pathSeg, used *normalizeRule) bool {
+	if a.Type == b.Type {
+		switch a.Type {
+		case bson.TypeEmbeddedDocument:
+			return c.equalDocuments(a.Document(), b.Document(), path, used)
+		case bson.TypeArray:
+			return c.equalArrays(bson.Raw(a.Array()), bson.Raw(b.Array()), path, used)
+		}
+		if bytes.Equal(a.Value, b.Value) {
+			return true
+		}
+	}
+
+	if isNumber(a) && isNumber(b) {
+		return c.equalNumbers(a, b, used)
+	}
+	if c.uuid && a.Type == bson.TypeBinary && b.Type == bson.TypeBinary {
+		aUUID, aOK := c.uuidBytes(a)
+		bUUID, bOK := c.uuidBytes(b)
+		if aOK && bOK && bytes.Equal(aUUID, bUUID) {
+			*used |= ruleUUID
+			return true
+		}
+		return false
+	}
+	if c.datePrecision > 0 && a.Type == bson.TypeDateTime && b.Type == bson.TypeDateTime {
+		delta := time.Duration(a.DateTime()-b.DateTime()) * time.Millisecond
+		if delta < c.datePrecision && -delta < c.datePrecision {
+			*used |= ruleDate
+			return true
+		}
+	}
+	return false
+}
+
+// equalNumbers 比较两个字节不同的数值, 数值相等时需要开启 numeric 规则才认为一致, 包括类型相同但表示不同的数值,
+// 例如 decimal 1.0 和 1.00、double 0.0 和 -0.0; 类型不同时 floatEpsilon 也需要同时开启 numeric 规则
+func (c *comparator) equalNumbers(a bson.RawValue, b bson.RawValue, used *normalizeRule) bool {
+	var rule normalizeRule
+	if a.Type != b.Type {
+		if !c.numeric {
+			return false
+		}
+		rule |= ruleNumeric
+	}
+	if c.numeric && compareNumbers(a, b) == 0 {
+		*used |= ruleNumeric
+		return true
+	}
+	if c.floatEpsilon > 0 {
+		aFloat, aNaN := numberToBigFloat(a)
+		bFloat, bNaN := numberToBigFloat(b)
+		if !aNaN && !bNaN {
+			x, _ := aFloat.Float64()
+			y, _ := bFloat.Float64()
+			if math.Abs(x-y) <= c.floatEpsilon {
+				*used |= rule | ruleFloatEpsilon
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// uuidBytes 返回 UUID 按标准字节序表示的 16 个字节, 旧版 UUID 按 uuidRepresentation 指定的驱动字节序转换
+func (c *comparator) uuidBytes(v bson.RawValue) ([]byte, bool) {
+	subtype, data := v.Binary()
+	if len(data) != 16 {
+		return nil, false
+	}
+	switch subtype {
+	case bson.TypeBinaryUUID:
+		return data, true
+	case bson.TypeBinaryUUIDOld:
+		uuid := append([]byte(nil), data...)
+		switch c.uuidRepresentation {
+		case "csharpLegacy":
+			reverseBytes(uuid[0:4])
+			reverseBytes(uuid[4:6])
+			reverseBytes(uuid[6:8])
+		case "javaLegacy":
+			reverseBytes(uuid[0:8])
+			reverseBytes(uuid[8:16])
+		}
+		return uuid, true
+	}
+	return nil, false
+}
+
+func isNumber(v bson.RawValue) bool {
+	switch v.Type {
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return true
+	}
+	return false
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
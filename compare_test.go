@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// usedRules 返回 comparator 已经生效的规范化规则
+func usedRules(c *comparator) normalizeRule {
+	var used normalizeRule
+	for i := range c.ruleUsed {
+		if c.ruleUsed[i].Load() > 0 {
+			used |= 1 << i
+		}
+	}
+	return used
+}
+
+func TestComparatorNormalize(t *testing.T) {
+	uuid := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	javaUUID := []byte{7, 6, 5, 4, 3, 2, 1, 0, 15, 14, 13, 12, 11, 10, 9, 8}
+	tests := []struct {
+		name string
+		cmp  *comparator
+		a, b interface{}
+		want bool
+		used normalizeRule
+	}{
+		{name: "strict int32 and double", a: int32(1), b: 1.0, want: false},
+		{name: "unordered int32 and int64", cmp: &comparator{unordered: true}, a: int32(1), b: int64(1), want: false},
+		// 只放宽字段顺序时不能放宽数值的比较
+		{name: "unordered decimal 1.0 and 1.00", cmp: &comparator{unordered: true}, a: decimal(t, "1.0"), b: decimal(t, "1.00"), want: false},
+		{name: "unordered 0.0 and -0.0", cmp: &comparator{unordered: true}, a: 0.0, b: math.Copysign(0, -1), want: false},
+		{name: "setArrays 0.0 and -0.0", cmp: &comparator{setArrays: parseFieldPatterns([]string{"x"})}, a: 0.0, b: math.Copysign(0, -1), want: false},
+		{name: "numeric int32 and int64", cmp: &comparator{numeric: true}, a: int32(1), b: int64(1), want: true, used: ruleNumeric},
+		{name: "numeric int64 and decimal", cmp: &comparator{numeric: true}, a: int64(5), b: decimal(t, "5.000"), want: true, used: ruleNumeric},
+		{name: "numeric decimal 1.0 and 1.00", cmp: &comparator{numeric: true}, a: decimal(t, "1.0"), b: decimal(t, "1.00"), want: true, used: ruleNumeric},
+		{name: "numeric 0.0 and -0.0", cmp: &comparator{numeric: true}, a: 0.0, b: math.Copysign(0, -1), want: true, used: ruleNumeric},
+		{name: "numeric different values", cmp: &comparator{numeric: true}, a: int32(1), b: 1.5, want: false},
+		{name: "floatEpsilon same type", cmp: &comparator{floatEpsilon: 0.01}, a: 1.0, b: 1.005, want: true, used: ruleFloatEpsilon},
+		{name: "floatEpsilon over epsilon", cmp: &comparator{floatEpsilon: 0.01}, a: 1.0, b: 1.02, want: false},
+		{name: "floatEpsilon different types", cmp: &comparator{floatEpsilon: 0.01}, a: int32(1), b: 1.005, want: false},
+		{name: "floatEpsilon with numeric", cmp: &comparator{numeric: true, floatEpsilon: 0.01}, a: int32(1), b: 1.005, want: true, used: ruleNumeric | ruleFloatEpsilon},
+		{name: "floatEpsilon NaN", cmp: &comparator{numeric: true, floatEpsilon: 0.01}, a: math.NaN(), b: 1.0, want: false},
+		{name: "uuid subtype 3 and 4", cmp: &comparator{uuid: true, uuidRepresentation: "standard"},
+			a: primitive.Binary{Subtype: 3, Data: uuid}, b: primitive.Binary{Subtype: 4, Data: uuid}, want: true, used: ruleUUID},
+		{name: "uuid javaLegacy", cmp: &comparator{uuid: true, uuidRepresentation: "javaLegacy"},
+			a: primitive.Binary{Subtype: 3, Data: javaUUID}, b: primitive.Binary{Subtype: 4, Data: uuid}, want: true, used: ruleUUID},
+		{name: "uuid different bytes", cmp: &comparator{uuid: true, uuidRepresentation: "standard"},
+			a: primitive.Binary{Subtype: 3, Data: javaUUID}, b: primitive.Binary{Subtype: 4, Data: uuid}, want: false},
+		{name: "uuid without rule", cmp: &comparator{numeric: true},
+			a: primitive.Binary{Subtype: 3, Data: uuid}, b: primitive.Binary{Subtype: 4, Data: uuid}, want: false},
+		{name: "date within precision", cmp: &comparator{datePrecision: time.Second}, a: primitive.DateTime(1000), b: primitive.DateTime(1999), want: true, used: ruleDate},
+		{name: "date at precision", cmp: &comparator{datePrecision: time.Second}, a: primitive.DateTime(1000), b: primitive.DateTime(2000), want: false},
+		{name: "nested numeric", cmp: &comparator{numeric: true}, a: bson.A{bson.D{{Key: "y", Value: int32(1)}}}, b: bson.A{bson.D{{Key: "y", Value: 1.0}}}, want: true, used: ruleNumeric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.cmp
+			if c == nil {
+				c = &comparator{}
+			}
+			c.fields = newFieldFilter(nil, nil)
+			a := marshalDoc(t, bson.D{{Key: "_id", Value: 1}, {Key: "x", Value: tt.a}})
+			b := marshalDoc(t, bson.D{{Key: "_id", Value: 1}, {Key: "x", Value: tt.b}})
+			if got := c.equal(a, b); got != tt.want {
+				t.Errorf("equal = %v, want %v", got, tt.want)
+			}
+			if got := usedRules(c); got != tt.used {
+				t.Errorf("used rules = %q, want %q", got, tt.used)
+			}
+		})
+	}
+}
+
+func TestComparatorUnordered(t *testing.T) {
+	tests := []struct {
+		name      string
+		unordered bool
+		a, b      bson.D
+		want      bool
+	}{
+		{name: "same bytes", a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}}, want: true},
+		{name: "strict field order", a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: 2}, {Key: "a", Value: 1}}, want: false},
+		{name: "unordered field order", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: 2}, {Key: "a", Value: 1}}, want: true},
+		{name: "unordered nested field order", unordered: true,
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 2}}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "y", Value: 2}, {Key: "x", Value: 1}}}},
+			want: true},
+		{name: "unordered documents inside arrays", unordered: true,
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.A{bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 2}}}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.A{bson.D{{Key: "y", Value: 2}, {Key: "x", Value: 1}}}}},
+			want: true},
+		{name: "unordered keeps array order", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.A{1, 2}}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.A{2, 1}}}, want: false},
+		{name: "unordered different value", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: 3}, {Key: "a", Value: 1}}, want: false},
+		{name: "unordered missing field", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: 1}}, want: false},
+		{name: "unordered extra field", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 1}}, want: false},
+		{name: "unordered does not relax types", unordered: true, a: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}}, b: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int64(1)}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &comparator{unordered: tt.unordered, fields: newFieldFilter(nil, nil)}
+			if got := c.equal(marshalDoc(t, tt.a), marshalDoc(t, tt.b)); got != tt.want {
+				t.Errorf("equal = %v, want %v", got, tt.want)
+			}
+			if used := usedRules(c); used != 0 {
+				t.Errorf("used rules = %q, want none", used)
+			}
+		})
+	}
+}
+
+func TestComparatorSetArrays(t *testing.T) {
+	tests := []struct {
+		name      string
+		setArrays []string
+		numeric   bool
+		a, b      interface{}
+		want      bool
+		used      normalizeRule
+	}{
+		{name: "reordered", setArrays: []string{"x"}, a: bson.A{"a", "b", "c"}, b: bson.A{"c", "a", "b"}, want: true, used: ruleSetArray},
+		{name: "not a set array", setArrays: []string{"y"}, a: bson.A{"a", "b"}, b: bson.A{"b", "a"}, want: false},
+		{name: "different multiplicity", setArrays: []string{"x"}, a: bson.A{"a", "a", "b"}, b: bson.A{"a", "b", "b"}, want: false},
+		{name: "different length", setArrays: []string{"x"}, a: bson.A{"a", "b"}, b: bson.A{"b", "a", "a"}, want: false},
+		{name: "reordered documents", setArrays: []string{"x"},
+			a: bson.A{bson.D{{Key: "k", Value: 1}}, bson.D{{Key: "k", Value: 2}}}, b: bson.A{bson.D{{Key: "k", Value: 2}}, bson.D{{Key: "k", Value: 1}}}, want: true, used: ruleSetArray},
+		{name: "nested in array elements", setArrays: []string{"x.tags"},
+			a: bson.A{bson.D{{Key: "tags", Value: bson.A{1, 2}}}}, b: bson.A{bson.D{{Key: "tags", Value: bson.A{2, 1}}}}, want: true, used: ruleSetArray},
+		{name: "nested with wildcard", setArrays: []string{"x.*.tags"},
+			a: bson.A{bson.D{{Key: "tags", Value: bson.A{1, 2}}}}, b: bson.A{bson.D{{Key: "tags", Value: bson.A{2, 1}}}}, want: true, used: ruleSetArray},
+		{name: "pattern matches the parent only", setArrays: []string{"x"},
+			a: bson.A{bson.D{{Key: "tags", Value: bson.A{1, 2}}}}, b: bson.A{bson.D{{Key: "tags", Value: bson.A{2, 1}}}}, want: false},
+		{name: "elements need numeric", setArrays: []string{"x"}, a: bson.A{int32(1), int32(2)}, b: bson.A{int32(2), int64(1)}, want: false},
+		{name: "elements with numeric", setArrays: []string{"x"}, numeric: true, a: bson.A{int32(1), int32(2)}, b: bson.A{int32(2), int64(1)}, want: true, used: ruleSetArray | ruleNumeric},
+		{name: "positional match with numeric", setArrays: []string{"x"}, numeric: true, a: bson.A{int32(1), int32(2)}, b: bson.A{int64(1), int32(2)}, want: true, used: ruleNumeric},
+		// 先按字节相同的元素配对, 剩下的元素再按规则比较
+		{name: "exact matches first", setArrays: []string{"x"}, numeric: true, a: bson.A{"a", int32(1), "b"}, b: bson.A{"b", int64(1), "a"}, want: true, used: ruleSetArray | ruleNumeric},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &comparator{setArrays: parseFieldPatterns(tt.setArrays), numeric: tt.numeric, fields: newFieldFilter(nil, nil)}
+			a := marshalDoc(t, bson.D{{Key: "_id", Value: 1}, {Key: "x", Value: tt.a}})
+			b := marshalDoc(t, bson.D{{Key: "_id", Value: 1}, {Key: "x", Value: tt.b}})
+			if got := c.equal(a, b); got != tt.want {
+				t.Errorf("equal = %v, want %v", got, tt.want)
+			}
+			if got := usedRules(c); got != tt.used {
+				t.Errorf("used rules = %q, want %q", got, tt.used)
+			}
+		})
+	}
+}
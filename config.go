@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// collConfig 是配置文件中单个集合的配置, 没有配置的项使用命令行参数
+type collConfig struct {
+	IgnoreFields []string `json:"ignoreFields"`
+	OnlyFields   []string `json:"onlyFields"`
+	SetArrays    []string `json:"setArrays"`
+
+	// Filter 是 Extended JSON 格式的查询条件, 只检查满足条件的文档, 例如 {"tenantId": 42}
+	Filter json.RawMessage `json:"filter"`
+	filter bson.D
+
+	// 判定检查结果的策略, 见 evaluate
+	MismatchRate       *float64 `json:"mismatchRate"`
+	MissingRate        *float64 `json:"missingRate"`
+	CountTolerance     *int64   `json:"countTolerance"`
+	CountToleranceRate *float64 `json:"countToleranceRate"`
+	IndexPolicy        string   `json:"indexPolicy"`
+}
+
+// fileConfig 是 config 参数指定的 JSON 配置文件, collections 的 key 为源集合的 "库名.集合名"
+type fileConfig struct {
+	Collections map[string]collConfig `json:"collections"`
+	NSMap       []string              `json:"nsMap"` // 命名空间映射规则, 格式同 nsMap 参数, 在参数中的规则之后匹配
+}
+
+// checkConfig 是加载后的配置文件, 没有指定 config 参数时为空
+var checkConfig fileConfig
+
+// loadConfig 加载 JSON 配置文件
+func loadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件 %s 失败: %v", path, err)
+	}
+	if err := json.Unmarshal(data, &checkConfig); err != nil {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	for ns, conf := range checkConfig.Collections {
+		if conf.Filter != nil {
+			if _, err := parseFilter(conf.Filter); err != nil {
+				return fmt.Errorf("配置文件中集合 %s 的 filter 不合法: %v", ns, err)
+			}
+		}
+		dbName, collName, _ := strings.Cut(ns, ".")
+		if err := checkPolicy("配置文件中集合 "+ns, collectionConfig(dbName, collName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectionConfig 返回集合的配置, 配置文件中没有配置的项使用命令行参数
+// 配置文件中显式配置为空列表时表示该集合不使用命令行参数
+func collectionConfig(dbName string, collName string) collConfig {
+	conf := checkConfig.Collections[dbName+"."+collName]
+	if conf.IgnoreFields == nil {
+		conf.IgnoreFields = splitList(*ignoreFields)
+	}
+	if conf.OnlyFields == nil {
+		conf.OnlyFields = splitList(*onlyFields)
+	}
+	if conf.SetArrays == nil {
+		conf.SetArrays = splitList(*setArrays)
+	}
+	if conf.MismatchRate == nil {
+		conf.MismatchRate = mismatchRate
+	}
+	if conf.MissingRate == nil {
+		conf.MissingRate = missingRate
+	}
+	if conf.CountTolerance == nil {
+		conf.CountTolerance = countTolerance
+	}
+	if conf.CountToleranceRate == nil {
+		conf.CountToleranceRate = countToleranceRate
+	}
+	if conf.IndexPolicy == "" {
+		conf.IndexPolicy = *indexPolicy
+	}
+	if conf.Filter == nil && *filter != "" {
+		conf.Filter = json.RawMessage(*filter)
+	}
+	// filter 参数和配置文件中的 filter 在加载时已经校验过
+	conf.filter, _ = parseFilter(conf.Filter)
+	return conf
+}
+
+// parseFilter 解析 Extended JSON 格式的查询条件, 为空时返回 nil
+func parseFilter(data []byte) (bson.D, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var filter bson.D
+	if err := bson.UnmarshalExtJSON(data, false, &filter); err != nil {
+		return nil, err
+	}
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// splitList 将逗号分隔的参数切分成列表, 忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
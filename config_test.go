@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    bson.D
+		wantErr bool
+	}{
+		{data: "", want: nil},
+		{data: "  \n", want: nil},
+		{data: "{}", want: nil},
+		{data: "null", want: nil},
+		{data: `{"tenantId": 42}`, want: bson.D{{Key: "tenantId", Value: int32(42)}}},
+		{data: `{"tenantId": {"$numberLong": "42"}, "status": {"$in": ["a", "b"]}}`,
+			want: bson.D{{Key: "tenantId", Value: int64(42)}, {Key: "status", Value: bson.D{{Key: "$in", Value: bson.A{"a", "b"}}}}}},
+		{data: `{"createdAt": {"$gte": {"$date": "2024-01-01T00:00:00Z"}}}`,
+			want: bson.D{{Key: "createdAt", Value: bson.D{{Key: "$gte", Value: primitive.NewDateTimeFromTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}}}}},
+		{data: `{"tenantId": }`, wantErr: true},
+		{data: `[{"tenantId": 42}]`, wantErr: true},
+		{data: `42`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFilter([]byte(tt.data))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFilter(%q) = %v, want error", tt.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.data, err)
+			continue
+		}
+		if !reflect.DeepEqual(marshalFilter(t, got), marshalFilter(t, tt.want)) {
+			t.Errorf("parseFilter(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+// marshalFilter 将查询条件转换成 BSON 比较, 避免 bson.D 中嵌套值的 Go 类型不同; nil 保持为 nil
+func marshalFilter(t *testing.T, filter bson.D) bson.Raw {
+	t.Helper()
+	if filter == nil {
+		return nil
+	}
+	return marshalDoc(t, filter)
+}
+
+func TestCollectionConfigFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		flag       string
+		configured json.RawMessage
+		want       bson.D
+	}{
+		{name: "no filter", want: nil},
+		{name: "command line filter", flag: `{"tenantId": 42}`, want: bson.D{{Key: "tenantId", Value: int32(42)}}},
+		{name: "config file filter", configured: json.RawMessage(`{"tenantId": 7}`), want: bson.D{{Key: "tenantId", Value: int32(7)}}},
+		{name: "config file overrides command line", flag: `{"tenantId": 42}`, configured: json.RawMessage(`{"region": "eu"}`), want: bson.D{{Key: "region", Value: "eu"}}},
+		{name: "empty config filter disables command line filter", flag: `{"tenantId": 42}`, configured: json.RawMessage(`{}`), want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, filter, tt.flag)
+			setFlag(t, &checkConfig, fileConfig{Collections: map[string]collConfig{"db1.orders": {Filter: tt.configured}}})
+			got := collectionConfig("db1", "orders").filter
+			if !reflect.DeepEqual(marshalFilter(t, got), marshalFilter(t, tt.want)) {
+				t.Errorf("filter = %v, want %v", got, tt.want)
+			}
+			// 其他集合只使用命令行参数
+			if other := collectionConfig("db1", "users").filter; (other == nil) != (tt.flag == "") {
+				t.Errorf("other collection filter = %v, want command line filter %q", other, tt.flag)
+			}
+		})
+	}
+}
+
+func TestComparatorMatch(t *testing.T) {
+	cond := bson.M{"_id": bson.M{"$gte": 10}}
+	tests := []struct {
+		name   string
+		filter bson.D
+		cond   bson.M
+		want   interface{}
+	}{
+		{name: "no filter", cond: cond, want: cond},
+		{name: "no condition", filter: bson.D{{Key: "tenantId", Value: 42}}, cond: bson.M{}, want: bson.D{{Key: "tenantId", Value: 42}}},
+		{name: "both", filter: bson.D{{Key: "tenantId", Value: 42}}, cond: cond,
+			want: bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "tenantId", Value: 42}}, cond}}}},
+	}
+	for _, tt := range tests {
+		c := &comparator{filter: tt.filter}
+		if got := c.match(tt.cond); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: match = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// countDocuments 按 method 获取集合的文档数
+// estimated 使用元数据, 速度快但在异常关机、孤儿文档等情况下不准确; exact 使用 CountDocuments 扫描索引或者全表;
+// collStats 使用 $collStats 聚合, 分片集群会同时返回每个分片的文档数; 指定了 filter 时只能使用 CountDocuments 统计满足条件的文档数
+func countDocuments(coll *mongo.Collection, method string, filter bson.D) (int64, map[string]int64, error) {
+	if filter != nil {
+		n, err := coll.CountDocuments(context.Background(), filter)
+		return n, nil, err
+	}
+	switch method {
+	case "estimated":
+		n, err := coll.EstimatedDocumentCount(context.Background())
+		return n, nil, err
+	case "exact":
+		n, err := coll.CountDocuments(context.Background(), bson.M{})
+		return n, nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$collStats", Value: bson.D{{Key: "count", Value: bson.D{}}}}},
+	}
+	cursor, err := coll.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	total := int64(0)
+	shards := make(map[string]int64)
+	for cursor.Next(context.Background()) {
+		n := int64Value(cursor.Current.Lookup("count"))
+		total += n
+		if shard, ok := cursor.Current.Lookup("shard").StringValueOK(); ok {
+			shards[shard] = n
+		}
+	}
+	return total, shards, cursor.Err()
+}
+
+// sampleCount 返回比对前打印和计算抽样条数使用的文档数
+// 没有 filter 时使用元数据估算, 否则使用 CountDocuments 统计满足条件的文档数
+func sampleCount(coll *mongo.Collection, filter bson.D) (int64, error) {
+	if filter == nil {
+		return coll.EstimatedDocumentCount(context.Background())
+	}
+	return coll.CountDocuments(context.Background(), filter)
+}
+
+// countComparison 是两边的文档数以及允许的差值
+type countComparison struct {
+	src     int64
+	dst     int64
+	allowed int64
+}
+
+// withinTolerance 返回文档数的差值是否在允许范围内
+func (c *countComparison) withinTolerance() bool {
+	delta := c.dst - c.src
+	return delta <= c.allowed && -delta <= c.allowed
+}
+
+// checkCount 比较两边的文档数, 差值超过 countTolerance 和 countToleranceRate*源文档数 中较大的一个时,
+// 按 countAction 返回错误或者只打印告警, 获取文档数失败时返回的 countComparison 为 nil
+func checkCount(srcColl *mongo.Collection, dstColl *mongo.Collection, conf collConfig) (*countComparison, error) {
+	srcCount, srcShards, err := countDocuments(srcColl, *countCheck, conf.filter)
+	if err != nil {
+		return nil, fmt.Errorf("获取源集合 %s 文档数失败: %v", collNS(srcColl), err)
+	}
+	dstCount, dstShards, err := countDocuments(dstColl, *countCheck, conf.filter)
+	if err != nil {
+		return nil, fmt.Errorf("获取目标集合 %s 文档数失败: %v", collNS(dstColl), err)
+	}
+	logShardCounts("源", collNS(srcColl), srcShards)
+	logShardCounts("目标", collNS(dstColl), dstShards)
+
+	counts := &countComparison{
+		src:     srcCount,
+		dst:     dstCount,
+		allowed: int64(math.Max(float64(*conf.CountTolerance), *conf.CountToleranceRate*float64(srcCount))),
+	}
+	delta := dstCount - srcCount
+	method := *countCheck
+	if conf.filter != nil {
+		method = "exact, filter"
+	}
+	log.Printf("集合 %s 文档数比对(%s), 源集群:%d, 目标集群:%d, 差值:%d, 允许差值:%d",
+		collNS(srcColl), method, srcCount, dstCount, delta, counts.allowed)
+	if counts.withinTolerance() {
+		return counts, nil
+	}
+
+	msg := fmt.Sprintf("集合 %s 文档数不一致, 源集群:%d, 目标集群:%d, 差值:%d, 超过允许差值:%d",
+		collNS(srcColl), srcCount, dstCount, delta, counts.allowed)
+	if *countAction == "warn" {
+		log.Printf("警告: %s", msg)
+		return counts, nil
+	}
+	return counts, errors.New(msg)
+}
+
+// logShardCounts 打印 $collStats 返回的每个分片的文档数
+func logShardCounts(side string, collName string, shards map[string]int64) {
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Printf("%s集合 %s 分片 %s 文档数:%d", side, collName, name, shards[name])
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dbHashResult 是 dbHash 预检查对单个集合的结论
+type dbHashResult int
+
+const (
+	dbHashUnknown dbHashResult = iota // 没有执行 dbHash, 或者两边的结果无法比较
+	dbHashEqual                       // 两边的 md5 一致, 可以跳过数据抽样
+	dbHashDiffer                      // 两边的 md5 不一致
+)
+
+// shardHashes 记录一个集合在每个分片上的 md5, 副本集只有一个分片名为空的结果
+type shardHashes map[string]string
+
+// compareDBHashes 在两边执行 dbHash 并比较每个源集合和对应的目标集合的 md5, dstColls 的 key 为源集合名, 目标集合可以在不同的库中
+// 任意一边执行失败时只打印日志, 所有集合都按 dbHashUnknown 处理, 继续正常抽样
+func compareDBHashes(srcDB *mongo.Database, dstColls map[string]*mongo.Collection) map[string]dbHashResult {
+	collNames := make([]string, 0, len(dstColls))
+	dstDBs := make(map[string]*mongo.Database)
+	dstNames := make(map[string][]string)
+	for name, coll := range dstColls {
+		collNames = append(collNames, name)
+		dbName := coll.Database().Name()
+		dstDBs[dbName] = coll.Database()
+		dstNames[dbName] = append(dstNames[dbName], coll.Name())
+	}
+	sort.Strings(collNames)
+
+	srcHashes, err := dbHashes(srcDB, *src, collNames)
+	if err != nil {
+		log.Printf("源集群执行 dbHash 失败, 跳过 dbHash 预检查: %v", err)
+		return nil
+	}
+	dstHashes := make(map[string]map[string]shardHashes, len(dstDBs))
+	for dbName, database := range dstDBs {
+		hashes, err := dbHashes(database, *dst, dstNames[dbName])
+		if err != nil {
+			log.Printf("目标集群执行 dbHash 失败, 跳过 dbHash 预检查: %v", err)
+			return nil
+		}
+		dstHashes[dbName] = hashes
+	}
+
+	results := make(map[string]dbHashResult, len(collNames))
+	for _, name := range collNames {
+		dstColl := dstColls[name]
+		dstHash := dstHashes[dstColl.Database().Name()][dstColl.Name()]
+		result := compareShardHashes(srcHashes[name], dstHash)
+		switch result {
+		case dbHashEqual:
+			log.Printf("集合 %s dbHash 一致", srcDB.Name()+"."+name)
+		case dbHashDiffer:
+			log.Printf("集合 %s dbHash 不一致, 源:%v, 目标:%v", srcDB.Name()+"."+name, srcHashes[name], dstHash)
+		default:
+			log.Printf("集合 %s dbHash 结果无法比较, 源:%v, 目标:%v", srcDB.Name()+"."+name, srcHashes[name], dstHash)
+		}
+		results[name] = result
+	}
+	return results
+}
+
+// compareShardHashes 比较两边的 md5, 两边的分片必须一一对应才能比较
+// 分片集群和副本集之间, 或者分片不同的两个分片集群之间, 数据分布不同, md5 没有可比性
+func compareShardHashes(srcHashes shardHashes, dstHashes shardHashes) dbHashResult {
+	if len(srcHashes) == 0 || len(srcHashes) != len(dstHashes) {
+		return dbHashUnknown
+	}
+	result := dbHashUnknown
+	for shard, srcMD5 := range srcHashes {
+		dstMD5, ok := dstHashes[shard]
+		if !ok || (srcMD5 == "") != (dstMD5 == "") {
+			return dbHashUnknown
+		}
+		if srcMD5 == "" {
+			// 两边在这个分片上都没有该集合
+			continue
+		}
+		if srcMD5 != dstMD5 {
+			result = dbHashDiffer
+		} else if result == dbHashUnknown {
+			result = dbHashEqual
+		}
+	}
+	return result
+}
+
+// dbHashes 返回 collNames 中每个集合的 md5
+// mongos 不支持 dbHash, 分片集群需要使用 uri 中的认证信息直连每个分片执行
+func dbHashes(database *mongo.Database, uri string, collNames []string) (map[string]shardHashes, error) {
+	shards, err := listShards(database.Client())
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]shardHashes, len(collNames))
+	if shards == nil {
+		collHashes, err := runDBHash(database, collNames)
+		if err != nil {
+			return nil, err
+		}
+		for name, md5 := range collHashes {
+			hashes[name] = shardHashes{"": md5}
+		}
+		return hashes, nil
+	}
+
+	for shard, host := range shards {
+		client, err := connectShard(uri, host)
+		if err != nil {
+			return nil, fmt.Errorf("连接分片 %s 失败: %v", shard, err)
+		}
+		collHashes, err := runDBHash(client.Database(database.Name()), collNames)
+		client.Disconnect(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("分片 %s: %v", shard, err)
+		}
+		for _, name := range collNames {
+			if hashes[name] == nil {
+				hashes[name] = shardHashes{}
+			}
+			// 未分片的集合只在主分片上有数据, 其他分片上的 md5 为空
+			hashes[name][shard] = collHashes[name]
+		}
+	}
+	return hashes, nil
+}
+
+// runDBHash 执行 dbHash 命令, 返回每个集合的 md5
+func runDBHash(database *mongo.Database, collNames []string) (map[string]string, error) {
+	cmd := bson.D{{Key: "dbHash", Value: 1}, {Key: "collections", Value: collNames}}
+	res, err := database.RunCommand(context.Background(), cmd).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("数据库 %s 执行 dbHash 失败: %v", database.Name(), err)
+	}
+	elements, err := res.Lookup("collections").Document().Elements()
+	if err != nil {
+		return nil, fmt.Errorf("解析数据库 %s 的 dbHash 结果失败: %v", database.Name(), err)
+	}
+	hashes := make(map[string]string, len(elements))
+	for _, elem := range elements {
+		hashes[elem.Key()] = elem.Value().StringValue()
+	}
+	return hashes, nil
+}
+
+// listShards 连接的是 mongos 时返回每个分片的名字和地址, 否则返回 nil
+func listShards(client *mongo.Client) (map[string]string, error) {
+	admin := client.Database("admin")
+	isMaster, err := admin.RunCommand(context.Background(), bson.D{{Key: "isMaster", Value: 1}}).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("执行 isMaster 失败: %v", err)
+	}
+	if msg, ok := isMaster.Lookup("msg").StringValueOK(); !ok || msg != "isdbgrid" {
+		return nil, nil
+	}
+
+	res, err := admin.RunCommand(context.Background(), bson.D{{Key: "listShards", Value: 1}}).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("执行 listShards 失败: %v", err)
+	}
+	values, err := res.Lookup("shards").Array().Values()
+	if err != nil {
+		return nil, fmt.Errorf("解析 listShards 结果失败: %v", err)
+	}
+	shards := make(map[string]string, len(values))
+	for _, v := range values {
+		shard := v.Document()
+		shards[shard.Lookup("_id").StringValue()] = shard.Lookup("host").StringValue()
+	}
+	return shards, nil
+}
+
+// connectShard 使用 uri 中的认证等参数连接分片, host 的格式为 "rs0/host1:port,host2:port"
+func connectShard(uri string, host string) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(uri)
+	setName, hosts := "", host
+	if i := strings.Index(host, "/"); i >= 0 {
+		setName, hosts = host[:i], host[i+1:]
+	}
+	clientOptions.SetHosts(strings.Split(hosts, ","))
+	if setName != "" {
+		clientOptions.SetReplicaSet(setName)
+	}
+	return mongo.Connect(context.Background(), clientOptions)
+}
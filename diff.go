@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// 控制台最多打印的差异条数, diffFile 中记录全部差异
+const maxConsoleDiffOps = 50
+
+// diffOp 是两条文档之间的一处差异, 按 RFC 6902 JSON Patch 描述如何将源文档修改成目标文档
+// oldValue/oldType/newType/truncated 不是 JSON Patch 定义的字段, 按规范应用 patch 时会被忽略
+type diffOp struct {
+	Op        string          `json:"op"`
+	Path      string          `json:"path"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	OldValue  json.RawMessage `json:"oldValue,omitempty"`
+	OldType   string          `json:"oldType,omitempty"`
+	NewType   string          `json:"newType,omitempty"`
+	Truncated bool            `json:"truncated,omitempty"`
+
+	field    string // 按 . 分隔的字段路径, 用于控制台输出
+	pattern  string // 数组下标替换成 * 的字段路径, 用于统计差异最多的字段
+	typeOnly bool   // 两边的值相同, 只有类型不同
+}
+
+// String 返回控制台输出的格式
+func (op diffOp) String() string {
+	var s string
+	switch op.Op {
+	case "add":
+		s = fmt.Sprintf("add %s: %s", op.field, op.Value)
+	case "remove":
+		s = fmt.Sprintf("remove %s: %s", op.field, op.OldValue)
+	default:
+		s = fmt.Sprintf("replace %s: %s -> %s", op.field, op.OldValue, op.Value)
+		if op.OldType != op.NewType {
+			s += fmt.Sprintf(" (类型 %s -> %s)", op.OldType, op.NewType)
+		}
+	}
+	return s
+}
+
+// diff 返回将源文档修改成目标文档需要的差异, 按 comparator 的规则一致的字段不算差异
+// 嵌入文档和数组递归比较; setArrays 指定的数组按多重集合比较, 不一致时整体替换
+func (c *comparator) diff(a bson.Raw, b bson.Raw) []diffOp {
+	var ops []diffOp
+	c.diffDocuments(c.fields.apply(a), c.fields.apply(b), nil, &ops)
+	return ops
+}
+
+func (c *comparator) diffDocuments(a bson.Raw, b bson.Raw, path []pathSeg, ops *[]diffOp) {
+	aElems, _ := a.Elements()
+	bElems, _ := b.Elements()
+	bValues := make(map[string]bson.RawValue, len(bElems))
+	for _, elem := range bElems {
+		bValues[elem.Key()] = elem.Value()
+	}
+	aKeys := make(map[string]bool, len(aElems))
+	for _, elem := range aElems {
+		aKeys[elem.Key()] = true
+		elemPath := append(path[:len(path):len(path)], pathSeg{name: elem.Key()})
+		if bValue, ok := bValues[elem.Key()]; ok {
+			c.diffValues(elem.Value(), bValue, elemPath, ops)
+		} else {
+			*ops = append(*ops, newDiffOp("remove", elemPath, elem.Value(), bson.RawValue{}))
+		}
+	}
+	for _, elem := range bElems {
+		if !aKeys[elem.Key()] {
+			elemPath := append(path[:len(path):len(path)], pathSeg{name: elem.Key()})
+			*ops = append(*ops, newDiffOp("add", elemPath, bson.RawValue{}, elem.Value()))
+		}
+	}
+}
+
+func (c *comparator) diffArrays(a bson.RawValue, b bson.RawValue, path []pathSeg, ops *[]diffOp) {
+	aValues, _ := bson.Raw(a.Array()).Values()
+	bValues, _ := bson.Raw(b.Array()).Values()
+	if c.isSetArray(path) {
+		// 元素顺序无关, 按位置列出差异没有意义
+		*ops = append(*ops, newDiffOp("replace", path, a, b))
+		return
+	}
+
+	n := len(aValues)
+	if len(bValues) < n {
+		n = len(bValues)
+	}
+	for i := 0; i < n; i++ {
+		c.diffValues(aValues[i], bValues[i], append(path[:len(path):len(path)], pathSeg{name: strconv.Itoa(i), index: true}), ops)
+	}
+	for i := n; i < len(bValues); i++ {
+		elemPath := append(path[:len(path):len(path)], pathSeg{name: strconv.Itoa(i), index: true})
+		*ops = append(*ops, newDiffOp("add", elemPath, bson.RawValue{}, bValues[i]))
+	}
+	// 从后往前删除, 按顺序应用 patch 时下标不会错位
+	for i := len(aValues) - 1; i >= n; i-- {
+		elemPath := append(path[:len(path):len(path)], pathSeg{name: strconv.Itoa(i), index: true})
+		*ops = append(*ops, newDiffOp("remove", elemPath, aValues[i], bson.RawValue{}))
+	}
+}
+
+func (c *comparator) diffValues(a bson.RawValue, b bson.RawValue, path []pathSeg, ops *[]diffOp) {
+	var used normalizeRule
+	if c.equalValues(a, b, path, &used) {
+		return
+	}
+	if a.Type == b.Type {
+		switch a.Type {
+		case bson.TypeEmbeddedDocument:
+			c.diffDocuments(a.Document(), b.Document(), path, ops)
+			return
+		case bson.TypeArray:
+			c.diffArrays(a, b, path, ops)
+			return
+		}
+	}
+	op := newDiffOp("replace", path, a, b)
+	op.typeOnly = a.Type != b.Type && compareValues(a, b) == 0
+	*ops = append(*ops, op)
+}
+
+// newDiffOp 创建一处差异, 不存在的一侧传入零值
+func newDiffOp(op string, path []pathSeg, oldValue bson.RawValue, newValue bson.RawValue) diffOp {
+	d := diffOp{Op: op, Path: jsonPointer(path), field: dottedPath(path), pattern: pathPattern(path)}
+	truncated := false
+	if oldValue.Type != 0 {
+		d.OldType = oldValue.Type.String()
+		d.OldValue, truncated = extJSON(oldValue, *diffValueLimit)
+	}
+	if newValue.Type != 0 {
+		d.NewType = newValue.Type.String()
+		d.Value, d.Truncated = extJSON(newValue, *diffValueLimit)
+	}
+	d.Truncated = d.Truncated || truncated
+	return d
+}
+
+// extJSON 将字段值转换成 relaxed 格式的扩展 JSON, 超过 limit 字节的值截断成字符串, limit 为 0 时不截断
+func extJSON(v bson.RawValue, limit int) (json.RawMessage, bool) {
+	doc := bsoncore.BuildDocument(nil, append(bsoncore.AppendHeader(nil, v.Type, "v"), v.Value...))
+	data, err := bson.MarshalExtJSON(bson.Raw(doc), false, false)
+	if err != nil {
+		data, _ = json.Marshal(v.String())
+		return data, false
+	}
+	// 去掉外层的 {"v": 和 }
+	data = data[len(`{"v":`) : len(data)-1]
+	if limit <= 0 || len(data) <= limit {
+		return data, false
+	}
+
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	data, _ = json.Marshal(fmt.Sprintf("%s...(共 %d 字节)", data[:cut], len(data)))
+	return data, true
+}
+
+// jsonPointerEscaper 按 RFC 6901 转义字段名中的 ~ 和 /
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// jsonPointer 返回 RFC 6901 格式的路径
+func jsonPointer(path []pathSeg) string {
+	var b strings.Builder
+	for _, seg := range path {
+		b.WriteByte('/')
+		b.WriteString(jsonPointerEscaper.Replace(seg.name))
+	}
+	return b.String()
+}
+
+// pathPattern 返回数组下标替换成 * 的字段路径
+func pathPattern(path []pathSeg) string {
+	names := make([]string, 0, len(path))
+	for _, seg := range path {
+		if seg.index {
+			names = append(names, "*")
+		} else {
+			names = append(names, seg.name)
+		}
+	}
+	return strings.Join(names, ".")
+}
+
+func dottedPath(path []pathSeg) string {
+	names := make([]string, 0, len(path))
+	for _, seg := range path {
+		names = append(names, seg.name)
+	}
+	return strings.Join(names, ".")
+}
+
+// reportMismatch 打印两条不一致文档的字段级差异, 指定了 diffFile 时同时写入 JSON Patch, 返回差异用于分类统计
+func (c *comparator) reportMismatch(id bson.RawValue, srcDoc bson.Raw, dstDoc bson.Raw) []diffOp {
+	ops := c.diff(srcDoc, dstDoc)
+	if len(ops) == 0 {
+		log.Printf("源集合 %s 数据不一致, _id:%v, 字段内容一致但字段顺序不同", c.ns, id.String())
+	} else {
+		lines := make([]string, 0, maxConsoleDiffOps+1)
+		for i, op := range ops {
+			if i == maxConsoleDiffOps {
+				lines = append(lines, fmt.Sprintf("    ... 省略 %d 处差异", len(ops)-maxConsoleDiffOps))
+				break
+			}
+			lines = append(lines, "    "+op.String())
+		}
+		log.Printf("源集合 %s 数据不一致, _id:%v, 差异 %d 处:\n%s", c.ns, id.String(), len(ops), strings.Join(lines, "\n"))
+	}
+	if diffOutput != nil {
+		diffOutput.write(c.ns, id, ops)
+	}
+	return ops
+}
+
+// diffFileWriter 按 JSON Lines 格式写入每条不一致文档的差异, 多个集合并发检查时需要加锁
+type diffFileWriter struct {
+	mu     sync.Mutex
+	file   *os.File
+	failed bool
+}
+
+// diffRecord 是 diffFile 中的一行
+type diffRecord struct {
+	NS    string          `json:"ns"`
+	ID    json.RawMessage `json:"_id"`
+	Patch []diffOp        `json:"patch"`
+}
+
+// diffOutput 是 diffFile 参数对应的输出, 没有指定时为 nil
+var diffOutput *diffFileWriter
+
+func openDiffFile(path string) (*diffFileWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("创建差异文件 %s 失败: %v", path, err)
+	}
+	return &diffFileWriter{file: file}, nil
+}
+
+func (w *diffFileWriter) write(ns string, id bson.RawValue, ops []diffOp) {
+	if ops == nil {
+		ops = []diffOp{}
+	}
+	idJSON, _ := extJSON(id, 0)
+	record := diffRecord{NS: ns, ID: idJSON, Patch: ops}
+	data, err := json.Marshal(record)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err == nil {
+		_, err = w.file.Write(append(data, '\n'))
+	}
+	if err != nil && !w.failed {
+		// 只打印第一次失败, 不影响比对
+		w.failed = true
+		log.Printf("写入差异文件失败: %v", err)
+	}
+}
+
+func (w *diffFileWriter) close() error {
+	return w.file.Close()
+}
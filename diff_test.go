@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// formatOps 将差异转换成便于比较的字符串: 操作 路径(统计路径) 源值 -> 目标值, 只有类型不同时加上 typeOnly
+func formatOps(ops []diffOp) []string {
+	lines := make([]string, 0, len(ops))
+	for _, op := range ops {
+		line := fmt.Sprintf("%s %s(%s) %s -> %s", op.Op, op.Path, op.pattern, string(op.OldValue), string(op.Value))
+		if op.OldType != op.NewType && op.OldType != "" && op.NewType != "" {
+			line += fmt.Sprintf(" [%s -> %s]", op.OldType, op.NewType)
+		}
+		if op.typeOnly {
+			line += " typeOnly"
+		}
+		if op.Truncated {
+			line += " truncated"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestComparatorDiff(t *testing.T) {
+	tests := []struct {
+		name      string
+		ignore    []string
+		setArrays []string
+		numeric   bool
+		a, b      bson.D
+		want      []string
+	}{
+		{
+			name: "replace",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(2)}},
+			want: []string{"replace /a(a) 1 -> 2"},
+		},
+		{
+			name: "type only",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1.0}},
+			want: []string{"replace /a(a) 1 -> 1.0 [32-bit integer -> double] typeOnly"},
+		},
+		{
+			name: "type and value",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(10)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: "10"}},
+			want: []string{`replace /a(a) 10 -> "10" [32-bit integer -> string]`},
+		},
+		{
+			name:    "numeric rule hides type only differences",
+			numeric: true,
+			a:       bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}, {Key: "b", Value: "x"}},
+			b:       bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1.0}, {Key: "b", Value: "y"}},
+			want:    []string{`replace /b(b) "x" -> "y"`},
+		},
+		{
+			name: "remove and add",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}, {Key: "b", Value: int32(2)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: int32(2)}, {Key: "c", Value: int32(3)}},
+			want: []string{"remove /a(a) 1 -> ", "add /c(c)  -> 3"},
+		},
+		{
+			name: "field order only",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: int32(1)}, {Key: "b", Value: int32(2)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: int32(2)}, {Key: "a", Value: int32(1)}},
+			want: []string{},
+		},
+		{
+			name: "nested document",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "x", Value: int32(1)}, {Key: "y", Value: true}}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "d", Value: bson.D{{Key: "x", Value: int32(2)}, {Key: "y", Value: true}}}},
+			want: []string{"replace /d/x(d.x) 1 -> 2"},
+		},
+		{
+			name: "array elements",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{bson.D{{Key: "price", Value: int32(1)}}, bson.D{{Key: "price", Value: int32(2)}}}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{bson.D{{Key: "price", Value: int32(1)}}, bson.D{{Key: "price", Value: int32(3)}}}}},
+			want: []string{"replace /items/1/price(items.*.price) 2 -> 3"},
+		},
+		{
+			name: "array grows",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "arr", Value: bson.A{int32(1)}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "arr", Value: bson.A{int32(1), int32(2), int32(3)}}},
+			want: []string{"add /arr/1(arr.*)  -> 2", "add /arr/2(arr.*)  -> 3"},
+		},
+		{
+			// 从后往前删除, 按顺序应用 patch 时下标不会错位
+			name: "array shrinks",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "arr", Value: bson.A{int32(1), int32(2), int32(3)}}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "arr", Value: bson.A{int32(5)}}},
+			want: []string{"replace /arr/0(arr.*) 1 -> 5", "remove /arr/2(arr.*) 3 -> ", "remove /arr/1(arr.*) 2 -> "},
+		},
+		{
+			name:      "set array is replaced as a whole",
+			setArrays: []string{"tags"},
+			a:         bson.D{{Key: "_id", Value: 1}, {Key: "tags", Value: bson.A{"a", "b"}}},
+			b:         bson.D{{Key: "_id", Value: 1}, {Key: "tags", Value: bson.A{"b", "c"}}},
+			want:      []string{`replace /tags(tags) ["a","b"] -> ["b","c"]`},
+		},
+		{
+			name: "json pointer escaping",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "a/b~c", Value: int32(1)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "a/b~c", Value: int32(2)}},
+			want: []string{"replace /a~1b~0c(a/b~c) 1 -> 2"},
+		},
+		{
+			name:   "ignored fields",
+			ignore: []string{"updatedAt"},
+			a:      bson.D{{Key: "_id", Value: 1}, {Key: "updatedAt", Value: int32(1)}, {Key: "a", Value: int32(1)}},
+			b:      bson.D{{Key: "_id", Value: 1}, {Key: "updatedAt", Value: int32(2)}, {Key: "a", Value: int32(2)}},
+			want:   []string{"replace /a(a) 1 -> 2"},
+		},
+		{
+			name: "truncated value",
+			a:    bson.D{{Key: "_id", Value: 1}, {Key: "s", Value: strings.Repeat("x", 30)}},
+			b:    bson.D{{Key: "_id", Value: 1}, {Key: "s", Value: "y"}},
+			want: []string{`replace /s(s) "\"xxxxxxxxxxx...(共 32 字节)" -> "y" truncated`},
+		},
+	}
+	setFlag(t, diffValueLimit, 12)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &comparator{fields: newFieldFilter(tt.ignore, nil), setArrays: parseFieldPatterns(tt.setArrays), numeric: tt.numeric}
+			got := formatOps(c.diff(marshalDoc(t, tt.a), marshalDoc(t, tt.b)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diff =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
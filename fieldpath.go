@@ -0,0 +1,231 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// pathSeg 是文档中一个字段的路径中的一段, 数组元素的路径段是它的下标
+type pathSeg struct {
+	name  string
+	index bool
+}
+
+// fieldPattern 是按 "." 切分后的字段路径, "*" 匹配任意一个字段名或者数组下标
+// 和 MongoDB 的查询语义一样, 数组下标可以省略, 例如 "items.price" 和 "items.*.price" 都匹配 items 数组中每个元素的 price
+type fieldPattern []string
+
+func parseFieldPatterns(paths []string) []fieldPattern {
+	patterns := make([]fieldPattern, 0, len(paths))
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			patterns = append(patterns, strings.Split(path, "."))
+		}
+	}
+	return patterns
+}
+
+// 字段路径和 fieldPattern 的匹配关系
+const (
+	matchNone   = iota // 不匹配
+	matchPrefix        // 字段路径是 pattern 的前缀, pattern 指向的是该字段内部的字段
+	matchFull          // pattern 匹配该字段或者该字段的祖先
+)
+
+// match 返回 pattern 和字段路径的匹配关系
+func (p fieldPattern) match(path []pathSeg) int {
+	if len(p) == 0 {
+		return matchFull
+	}
+	if len(path) == 0 {
+		return matchPrefix
+	}
+	result := matchNone
+	if p[0] == "*" || p[0] == path[0].name {
+		result = p[1:].match(path[1:])
+	}
+	if path[0].index && result != matchFull {
+		// 省略数组下标
+		if r := p.match(path[1:]); r > result {
+			result = r
+		}
+	}
+	return result
+}
+
+// matchExact 返回 pattern 是否恰好匹配该字段, 而不是该字段的祖先
+func (p fieldPattern) matchExact(path []pathSeg) bool {
+	if len(path) == 0 {
+		return len(p) == 0
+	}
+	if len(p) > 0 && (p[0] == "*" || p[0] == path[0].name) && p[1:].matchExact(path[1:]) {
+		return true
+	}
+	// 省略数组下标
+	return path[0].index && p.matchExact(path[1:])
+}
+
+// pushdown 返回 pattern 中可以下推到服务端投影的前缀, 遇到通配符或者数组下标时截断
+func (p fieldPattern) pushdown() string {
+	prefix := make([]string, 0, len(p))
+	for _, seg := range p {
+		if _, err := strconv.Atoi(seg); seg == "*" || err == nil {
+			break
+		}
+		prefix = append(prefix, seg)
+	}
+	return strings.Join(prefix, ".")
+}
+
+// 过滤文档时对单个字段的处理方式
+const (
+	actionDrop           = iota // 删除该字段
+	actionKeep                  // 完整保留该字段
+	actionDescend               // 保留该字段, 但需要删除其内部的部分字段
+	actionDescendPartial        // 只保留该字段内部的部分字段, 该字段不是文档或者数组时删除
+)
+
+// fieldFilter 在比对前对两边的文档删除 ignore 匹配的字段, 并且在 only 不为空时只保留 only 匹配的字段
+type fieldFilter struct {
+	ignore []fieldPattern
+	only   []fieldPattern
+}
+
+func newFieldFilter(ignore []string, only []string) *fieldFilter {
+	return &fieldFilter{
+		ignore: parseFieldPatterns(ignore),
+		only:   parseFieldPatterns(only),
+	}
+}
+
+func (f *fieldFilter) empty() bool {
+	return len(f.ignore) == 0 && len(f.only) == 0
+}
+
+// decide 决定字段的处理方式, 顶层的 _id 总是保留
+func (f *fieldFilter) decide(path []pathSeg) int {
+	if len(path) == 1 && path[0].name == "_id" {
+		return actionKeep
+	}
+
+	action := actionKeep
+	if len(f.only) > 0 {
+		best := matchNone
+		for _, p := range f.only {
+			if r := p.match(path); r > best {
+				best = r
+			}
+		}
+		switch best {
+		case matchNone:
+			return actionDrop
+		case matchPrefix:
+			action = actionDescendPartial
+		}
+	}
+	for _, p := range f.ignore {
+		switch p.match(path) {
+		case matchFull:
+			return actionDrop
+		case matchPrefix:
+			if action == actionKeep {
+				action = actionDescend
+			}
+		}
+	}
+	return action
+}
+
+// apply 返回过滤后的文档
+func (f *fieldFilter) apply(doc bson.Raw) bson.Raw {
+	if f.empty() {
+		return doc
+	}
+	return f.filterDocument(nil, doc, nil, false)
+}
+
+// filterDocument 将过滤后的文档或者数组追加到 dst, 数组元素删除后重新编号
+func (f *fieldFilter) filterDocument(dst []byte, doc bson.Raw, path []pathSeg, isArray bool) []byte {
+	idx, dst := bsoncore.AppendDocumentStart(dst)
+	elems, _ := doc.Elements()
+	n := 0
+	for _, elem := range elems {
+		elemPath := append(path[:len(path):len(path)], pathSeg{name: elem.Key(), index: isArray})
+		action := f.decide(elemPath)
+		if action == actionDrop {
+			continue
+		}
+
+		key := elem.Key()
+		if isArray {
+			key = strconv.Itoa(n)
+		}
+		value := elem.Value()
+		switch {
+		case action != actionKeep && value.Type == bson.TypeEmbeddedDocument:
+			dst = bsoncore.AppendHeader(dst, value.Type, key)
+			dst = f.filterDocument(dst, value.Document(), elemPath, false)
+		case action != actionKeep && value.Type == bson.TypeArray:
+			dst = bsoncore.AppendHeader(dst, value.Type, key)
+			dst = f.filterDocument(dst, bson.Raw(value.Array()), elemPath, true)
+		case action == actionDescendPartial:
+			// 需要的是该字段内部的字段, 但该字段不是文档或者数组
+			continue
+		default:
+			dst = bsoncore.AppendHeader(dst, value.Type, key)
+			dst = append(dst, value.Value...)
+		}
+		n++
+	}
+	dst, _ = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst
+}
+
+// projection 返回可以下推到服务端的投影, 减少需要传输的数据, 无法下推时返回 nil
+// only 不为空时使用包含投影, 否则对不含通配符和数组下标的 ignore 字段使用排除投影
+func (f *fieldFilter) projection() bson.D {
+	var paths []string
+	value := 0
+	if len(f.only) > 0 {
+		value = 1
+		for _, p := range f.only {
+			path := p.pushdown()
+			if path == "" {
+				// 第一段就是通配符, 需要完整的文档
+				return nil
+			}
+			paths = append(paths, path)
+		}
+	} else {
+		for _, p := range f.ignore {
+			if path := p.pushdown(); path == strings.Join(p, ".") && path != "_id" {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	// 同时投影父字段和子字段会报 Path collision 错误, 只保留父字段
+	var projection bson.D
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		if seen[path] || hasAncestor(path, paths) {
+			continue
+		}
+		seen[path] = true
+		projection = append(projection, bson.E{Key: path, Value: value})
+	}
+	return projection
+}
+
+// hasAncestor 返回 paths 中是否有 path 的父字段
+func hasAncestor(path string, paths []string) bool {
+	for _, other := range paths {
+		if strings.HasPrefix(path, other+".") {
+			return true
+		}
+	}
+	return false
+}
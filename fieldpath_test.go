@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func marshalDoc(t *testing.T, doc bson.D) bson.Raw {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal(%v): %v", doc, err)
+	}
+	return raw
+}
+
+func TestFieldFilterApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore []string
+		only   []string
+		doc    bson.D
+		want   bson.D
+	}{
+		{
+			name: "empty filter",
+			doc:  bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+		},
+		{
+			name:   "ignore top level field",
+			ignore: []string{"a", " "},
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}},
+			want:   bson.D{{Key: "_id", Value: 1}, {Key: "b", Value: 2}},
+		},
+		{
+			name:   "_id is always kept",
+			ignore: []string{"_id"},
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+			want:   bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+		},
+		{
+			name:   "ignore nested field",
+			ignore: []string{"a.b"},
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "b", Value: 1}, {Key: "c", Value: 2}}}},
+			want:   bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "c", Value: 2}}}},
+		},
+		{
+			name:   "ignore field inside array elements without index",
+			ignore: []string{"items.price"},
+			doc: bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{
+				bson.D{{Key: "price", Value: 1}, {Key: "q", Value: 2}},
+				bson.D{{Key: "price", Value: 3}},
+			}}},
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{
+				bson.D{{Key: "q", Value: 2}},
+				bson.D{},
+			}}},
+		},
+		{
+			name:   "ignore array element renumbers the rest",
+			ignore: []string{"items.1"},
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{1, 2, 3}}},
+			want:   bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{1, 3}}},
+		},
+		{
+			name:   "wildcard",
+			ignore: []string{"*.x"},
+			doc: bson.D{{Key: "_id", Value: 1},
+				{Key: "a", Value: bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 1}}},
+				{Key: "b", Value: bson.D{{Key: "x", Value: 2}}},
+				{Key: "x", Value: 3},
+			},
+			want: bson.D{{Key: "_id", Value: 1},
+				{Key: "a", Value: bson.D{{Key: "y", Value: 1}}},
+				{Key: "b", Value: bson.D{}},
+				{Key: "x", Value: 3},
+			},
+		},
+		{
+			name: "only top level field",
+			only: []string{"a"},
+			doc:  bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}},
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 1}},
+		},
+		{
+			name: "only nested field",
+			only: []string{"a.b"},
+			doc:  bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "b", Value: 1}, {Key: "c", Value: 2}}}, {Key: "d", Value: 1}},
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "b", Value: 1}}}},
+		},
+		{
+			name: "only nested field of a scalar",
+			only: []string{"a.b"},
+			doc:  bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: 5}},
+			want: bson.D{{Key: "_id", Value: 1}},
+		},
+		{
+			name: "only field inside array elements",
+			only: []string{"items.price"},
+			doc: bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{
+				bson.D{{Key: "price", Value: 1}, {Key: "q", Value: 2}},
+				7,
+				bson.D{{Key: "price", Value: 3}},
+			}}},
+			want: bson.D{{Key: "_id", Value: 1}, {Key: "items", Value: bson.A{
+				bson.D{{Key: "price", Value: 1}},
+				bson.D{{Key: "price", Value: 3}},
+			}}},
+		},
+		{
+			name:   "only and ignore",
+			only:   []string{"a"},
+			ignore: []string{"a.x"},
+			doc:    bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "x", Value: 1}, {Key: "y", Value: 2}}}, {Key: "b", Value: 3}},
+			want:   bson.D{{Key: "_id", Value: 1}, {Key: "a", Value: bson.D{{Key: "y", Value: 2}}}},
+		},
+	}
+	for _, tt := range tests {
+		got := newFieldFilter(tt.ignore, tt.only).apply(marshalDoc(t, tt.doc))
+		if want := marshalDoc(t, tt.want); !bytes.Equal(got, want) {
+			t.Errorf("%s: apply = %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestFieldFilterProjection(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore []string
+		only   []string
+		want   bson.D
+	}{
+		{
+			name: "empty filter",
+			want: nil,
+		},
+		{
+			name:   "ignore skips _id, wildcards and array indexes",
+			ignore: []string{"a", "b.c", "items.*.price", "_id", "list.0"},
+			want:   bson.D{{Key: "a", Value: 0}, {Key: "b.c", Value: 0}},
+		},
+		{
+			name:   "ignore keeps only the ancestor",
+			ignore: []string{"a.b", "a"},
+			want:   bson.D{{Key: "a", Value: 0}},
+		},
+		{
+			name: "only truncates at wildcards and indexes",
+			only: []string{"a.b", "c.*.d", "e.0"},
+			want: bson.D{{Key: "a.b", Value: 1}, {Key: "c", Value: 1}, {Key: "e", Value: 1}},
+		},
+		{
+			name: "only removes path collisions and duplicates",
+			only: []string{"a.b", "a", "c", "c"},
+			want: bson.D{{Key: "a", Value: 1}, {Key: "c", Value: 1}},
+		},
+		{
+			name: "only starting with a wildcard needs the whole document",
+			only: []string{"a", "*.x"},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		if got := newFieldFilter(tt.ignore, tt.only).projection(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: projection = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 计算区间摘要时对文档哈希取模使用的两个素数, 取模后求和不会溢出 int64
+const (
+	hashModulus1 = 1048573
+	hashModulus2 = 1048571
+)
+
+// digestDepth 是计算文档哈希时逐个字段保留类型和值的最大嵌套层数, 更深的子文档和数组直接使用 $toHashedIndexKey
+const digestDepth = 8
+
+// rangeDigest 是一个 _id 区间的摘要: 文档数以及所有文档哈希值取模后的和
+// 求和与文档的返回顺序无关, 所以两边存储顺序不同也能得到相同的摘要
+type rangeDigest struct {
+	count int64
+	sum1  int64
+	sum2  int64
+}
+
+// canonicalExpr 返回将 value 转换成保留类型和值的嵌套数组的表达式, 用于计算文档的哈希
+// $toHashedIndexKey 会把浮点数截断成整数, 并且不区分 int/long/double, 所以每个值都转换成 [类型, 字符串形式, 哈希]:
+// 子文档和数组转换成 [类型, [[字段名, 值]...]], 数组的字段名为空, 超过 depth 层时只保留类型和 $toHashedIndexKey
+func canonicalExpr(value interface{}, depth int) bson.D {
+	v := "v" + strconv.Itoa(depth)
+	ref := "$$" + v
+	typ := bson.D{{Key: "$type", Value: ref}}
+	hashed := bson.D{{Key: "$toHashedIndexKey", Value: ref}}
+	leaf := bson.A{typ, bson.D{{Key: "$convert", Value: bson.D{
+		{Key: "input", Value: ref}, {Key: "to", Value: "string"}, {Key: "onError", Value: nil}, {Key: "onNull", Value: nil},
+	}}}, hashed}
+	container := bson.D{{Key: "$in", Value: bson.A{typ, bson.A{"object", "array"}}}}
+	var in bson.D
+	if depth == 0 {
+		in = bson.D{{Key: "$cond", Value: bson.A{container, bson.A{typ, hashed}, leaf}}}
+	} else {
+		f := "f" + strconv.Itoa(depth)
+		fields := bson.D{{Key: "$cond", Value: bson.A{
+			bson.D{{Key: "$isArray", Value: ref}},
+			bson.D{{Key: "$map", Value: bson.D{
+				{Key: "input", Value: ref}, {Key: "as", Value: "e"},
+				{Key: "in", Value: bson.D{{Key: "k", Value: ""}, {Key: "v", Value: "$$e"}}},
+			}}},
+			bson.D{{Key: "$objectToArray", Value: ref}},
+		}}}
+		in = bson.D{{Key: "$cond", Value: bson.A{
+			container,
+			bson.A{typ, bson.D{{Key: "$map", Value: bson.D{
+				{Key: "input", Value: fields}, {Key: "as", Value: f},
+				{Key: "in", Value: bson.A{"$$" + f + ".k", canonicalExpr("$$"+f+".v", depth-1)}},
+			}}}},
+			leaf,
+		}}}
+	}
+	return bson.D{{Key: "$let", Value: bson.D{
+		{Key: "vars", Value: bson.D{{Key: v, Value: value}}},
+		{Key: "in", Value: in},
+	}}}
+}
+
+// digestRange 在服务端使用聚合计算区间的摘要, 只有一条结果文档通过网络返回
+// 文档先按 canonicalExpr 转换再使用 $toHashedIndexKey 计算哈希, 只对满足 filter 的文档计算, 有投影时只对投影后的文档计算哈希
+func digestRange(coll *mongo.Collection, r idRange, cmp *comparator) (rangeDigest, error) {
+	// 第二个分量先将哈希值缩小再取模, 使两个分量相互独立
+	part1 := bson.D{{Key: "$abs", Value: bson.D{{Key: "$mod", Value: bson.A{"$h", hashModulus1}}}}}
+	part2 := bson.D{{Key: "$abs", Value: bson.D{{Key: "$mod", Value: bson.A{
+		bson.D{{Key: "$trunc", Value: bson.D{{Key: "$divide", Value: bson.A{"$h", hashModulus1}}}}},
+		hashModulus2,
+	}}}}}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: cmp.match(r.filter())}}}
+	if cmp.projection != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: cmp.projection}})
+	}
+	pipeline = append(pipeline, mongo.Pipeline{
+		{{Key: "$project", Value: bson.D{{Key: "h", Value: bson.D{{Key: "$toHashedIndexKey", Value: canonicalExpr("$$ROOT", digestDepth)}}}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "sum1", Value: bson.D{{Key: "$sum", Value: part1}}},
+			{Key: "sum2", Value: bson.D{{Key: "$sum", Value: part2}}},
+		}}},
+	}...)
+	ctx, end := readContext(context.Background(), coll)
+	defer end()
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return rangeDigest{}, fmt.Errorf("计算集合 %s 区间 %s 的摘要失败: %v", collNS(coll), r, err)
+	}
+	defer cursor.Close(ctx)
+
+	digest := rangeDigest{}
+	if cursor.Next(ctx) {
+		digest.count = int64Value(cursor.Current.Lookup("count"))
+		digest.sum1 = int64Value(cursor.Current.Lookup("sum1"))
+		digest.sum2 = int64Value(cursor.Current.Lookup("sum2"))
+	}
+	if err := cursor.Err(); err != nil {
+		return rangeDigest{}, fmt.Errorf("计算集合 %s 区间 %s 的摘要失败: %v", collNS(coll), r, err)
+	}
+	return digest, nil
+}
+
+// int64Value 读取 $sum 的结果, 服务端会根据数值大小返回 int32/int64/double
+func int64Value(v bson.RawValue) int64 {
+	switch v.Type {
+	case bson.TypeInt32:
+		return int64(v.Int32())
+	case bson.TypeInt64:
+		return v.Int64()
+	case bson.TypeDouble:
+		return int64(v.Double())
+	}
+	return 0
+}
+
+// checkCollectionByHash 将 _id 空间切分成区间, 在两边服务端分别计算每个区间的摘要并比较
+// 摘要一致的区间直接跳过, 不一致的区间继续递归切分, 直到两边文档数都不超过 hashLeafSize 时再按 _id 归并比对
+// 只有不一致区间的文档才需要通过网络传输, 全量校验的代价远小于全表扫描
+func checkCollectionByHash(task *checkTask) error {
+	srcColl, dstColl := task.srcColl, task.dstColl
+	srcCount, err := sampleCount(srcColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取源集合 %s 文档数失败: %v", collNS(srcColl), err)
+	}
+
+	dstCount, err := sampleCount(dstColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取目标集合 %s 文档数失败: %v", collNS(dstColl), err)
+	}
+
+	log.Printf("开始比对集合:%s, 源集群文档数:%d, 目标集群文档数:%d, 比对方式: 区间摘要, 切分数:%d, 叶子区间大小:%d",
+		collNS(srcColl), srcCount, dstCount, *hashFanout, *hashLeafSize)
+
+	// 顶层区间只按源集群切分, 两边的 _id 有多种类型时, 其他类型的 _id 不会落在任何区间内, 改为整个集合按 _id 归并比对
+	uniform, err := uniformIDType(srcColl, dstColl)
+	if err != nil {
+		return err
+	}
+	if !uniform {
+		log.Printf("集合 %s 两边的 _id 包含多种类型, 无法按 _id 区间计算摘要, 改为按 _id 排序归并比对", collNS(srcColl))
+		task.result.plan("merge", forward, srcCount)
+		if err := mergeJoin(context.Background(), task, bson.M{}, srcCount); err != nil {
+			return err
+		}
+		result := task.result
+		log.Printf("集合 %s 全量比对完成, 一致 %d 条, 仅源集群存在 %d 条, 仅目标集群存在 %d 条, 内容不一致 %d 条",
+			collNS(srcColl), result.same.Load(), result.findings[findingMissing].Load(), result.findings[findingExtra].Load(), result.contentDiffers())
+		return nil
+	}
+
+	task.result.plan("hash", forward, srcCount)
+	queue, err := splitIDRange(srcColl, idRange{}, *hashFanout)
+	if err != nil {
+		return err
+	}
+
+	result := task.result
+	digests := 0
+	leaves := 0
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+
+		srcDigest, err := digestRange(srcColl, r, task.cmp)
+		if err != nil {
+			return err
+		}
+		dstDigest, err := digestRange(dstColl, r, task.cmp)
+		if err != nil {
+			return err
+		}
+		digests++
+		if srcDigest == dstDigest {
+			result.same.Add(srcDigest.count)
+			continue
+		}
+
+		log.Printf("集合 %s 区间 %s 摘要不一致, 源集群文档数:%d, 目标集群文档数:%d",
+			collNS(srcColl), r, srcDigest.count, dstDigest.count)
+		var subRanges []idRange
+		if srcDigest.count > int64(*hashLeafSize) || dstDigest.count > int64(*hashLeafSize) {
+			// 按文档多的一侧切分, 这样只在一侧存在的文档也能被切分开
+			splitColl := srcColl
+			if dstDigest.count > srcDigest.count {
+				splitColl = dstColl
+			}
+			subRanges, err = splitIDRange(splitColl, r, *hashFanout)
+			if err != nil {
+				return err
+			}
+		}
+		if len(subRanges) > 1 {
+			queue = append(queue, subRanges...)
+			continue
+		}
+
+		// 区间足够小或者无法继续切分, 直接按 _id 归并比对找出不一致的文档
+		leaves++
+		if err := mergeJoin(context.Background(), task, r.filter(), 0); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("集合 %s 区间摘要比对完成, 比较区间 %d 个, 归并比对区间 %d 个, 一致 %d 条, 仅源集群存在 %d 条, 仅目标集群存在 %d 条, 内容不一致 %d 条",
+		collNS(srcColl), digests, leaves, result.same.Load(), result.findings[findingMissing].Load(), result.findings[findingExtra].Load(), result.contentDiffers())
+	return nil
+}
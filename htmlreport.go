@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+)
+
+// htmlTemplate 是自包含的 HTML 报告模板, 不依赖外部的样式和脚本
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"keys":  func() []string { return findingKeys[:] },
+	"empty": func(d *inventoryDiff) bool { return d.empty() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mongocheck 检查报告</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; margin: 8px 0 16px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+code, pre { font-family: Menlo, Consolas, monospace; font-size: 12px; white-space: pre-wrap; word-break: break-all; }
+.pass { color: #1a7f37; font-weight: bold; }
+.warn { color: #9a6700; font-weight: bold; }
+.fail { color: #cf222e; font-weight: bold; }
+.add { background: #e6ffec; }
+.remove { background: #ffebe9; }
+.replace { background: #fff8c5; }
+details { margin: 4px 0; }
+</style>
+</head>
+<body>
+<h1>mongocheck 检查报告</h1>
+<table>
+<tr><th>结论</th><td class="{{.Verdict}}">{{.Verdict}}</td></tr>
+<tr><th>退出码</th><td>{{.ExitCode}}</td></tr>
+<tr><th>开始时间</th><td>{{.StartTime.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>耗时(秒)</th><td>{{printf "%.1f" .Duration}}</td></tr>
+<tr><th>源集群版本</th><td>{{.Versions.Src}}</td></tr>
+<tr><th>目标集群版本</th><td>{{.Versions.Dst}}</td></tr>
+{{with .Snapshot}}<tr><th>源集群快照</th><td>atClusterTime {{.AtClusterTime.T}}.{{.AtClusterTime.I}}{{if .Fallback}} <span class="warn">快照历史不足, 已回退为普通读</span>{{end}}</td></tr>{{end}}
+</table>
+
+{{with .Namespaces}}<h2>命名空间 <span class="{{if empty .}}pass{{else}}fail{{end}}">{{if empty .}}一致{{else}}不一致{{end}}</span></h2>
+{{if .OnlyInSrc}}<h3>只在源集群存在的集合</h3><ul>{{range .OnlyInSrc}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .OnlyInDst}}<h3>只在目标集群存在的集合</h3><ul>{{range .OnlyInDst}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .DBsOnlyInDst}}<h3>只在目标集群存在的库</h3><ul>{{range .DBsOnlyInDst}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .TypeDiffers}}<h3>类型不一致</h3>
+<table><tr><th>源集群</th><th>目标集群</th></tr>{{range .TypeDiffers}}<tr><td><code>{{.Src}}</code> {{.SrcType}}</td><td><code>{{.Dst}}</code> {{.DstType}}</td></tr>{{end}}</table>{{end}}
+{{if .OptionsDiffer}}<h3>创建参数不一致</h3>
+<table><tr><th>源集群</th><th>目标集群</th></tr>{{range .OptionsDiffer}}<tr><td><code>{{.Src}}</code><pre>{{printf "%s" .SrcOptions}}</pre></td><td><code>{{.Dst}}</code><pre>{{printf "%s" .DstOptions}}</pre></td></tr>{{end}}</table>{{end}}
+{{end}}
+
+<h2>集合</h2>
+<table>
+<tr><th>集合</th><th>结论</th><th>比对方式</th><th>计划比对</th><th>比对文档数</th><th>一致</th>{{range keys}}<th>{{.}}</th>{{end}}<th>耗时(秒)</th><th>文档/秒</th></tr>
+{{range .Collections}}{{$coll := .}}
+<tr><td><a href="#{{.NS}}">{{.NS}}</a>{{if and .DstNS (ne .DstNS .NS)}} → {{.DstNS}}{{end}}{{if .SnapshotFallback}} <span class="warn">普通读</span>{{end}}</td><td class="{{.Verdict}}">{{.Verdict}}</td><td>{{.Mode}}</td><td>{{.SampleSize}}</td><td>{{.Compared}}</td><td>{{.Same}}</td>{{range keys}}<td>{{index $coll.Findings .}}</td>{{end}}<td>{{printf "%.1f" .Duration}}</td><td>{{printf "%.0f" .Throughput}}</td></tr>
+{{end}}
+<tr><th>合计</th><th></th><th></th><th></th><th></th><th>{{index .Totals "same"}}</th>{{range keys}}<th>{{index $.Totals .}}</th>{{end}}<th></th><th></th></tr>
+</table>
+
+{{range .Collections}}
+<h2 id="{{.NS}}">{{.NS}}{{if and .DstNS (ne .DstNS .NS)}} → {{.DstNS}}{{end}} <span class="{{.Verdict}}">{{.Verdict}}</span></h2>
+{{if .SnapshotFallback}}<p class="warn">比对期间快照读回退为普通读, 结果不是同一时间点的快照</p>{{end}}
+{{if .Reasons}}<h3>结论原因</h3><ul>{{range .Reasons}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Errors}}<h3>错误</h3><ul>{{range .Errors}}<li><code>{{.}}</code></li>{{end}}</ul>{{end}}
+{{if .TopPaths}}<h3>差异最多的字段</h3>
+<table><tr><th>字段</th><th>文档数</th></tr>{{range .TopPaths}}<tr><td><code>{{.Path}}</code></td><td>{{.Count}}</td></tr>{{end}}</table>{{end}}
+{{if .Mismatches}}<h3>不一致明细</h3>
+{{range .Mismatches}}<details><summary>{{.Kind}} <code>_id: {{printf "%s" .ID}}</code></summary>
+{{if .Patch}}<table><tr><th>操作</th><th>字段</th><th>源集群</th><th>目标集群</th></tr>
+{{range .Patch}}<tr class="{{.Op}}"><td>{{.Op}}</td><td><code>{{.Path}}</code></td><td><code>{{printf "%s" .OldValue}}</code>{{if .OldType}}<br><small>{{.OldType}}</small>{{end}}</td><td><code>{{printf "%s" .Value}}</code>{{if .NewType}}<br><small>{{.NewType}}</small>{{end}}</td></tr>
+{{end}}</table>{{end}}
+</details>
+{{end}}{{if .MismatchesOmitted}}<p>... 省略 {{.MismatchesOmitted}} 条</p>{{end}}{{end}}
+{{with .Converged}}<h3>复查后一致 {{.Count}} 条, 最长 {{printf "%.1f" .MaxSeconds}} 秒</h3>
+{{if .Details}}<details><summary>明细</summary><table><tr><th>类别</th><th>_id</th><th>复查次数</th><th>耗时(秒)</th></tr>
+{{range .Details}}<tr><td>{{.Kind}}</td><td><code>{{printf "%s" .ID}}</code></td><td>{{.Attempts}}</td><td>{{printf "%.1f" .Seconds}}</td></tr>
+{{end}}</table></details>{{end}}{{end}}
+{{with .Indexes}}<h3>索引 <span class="{{if .Equal}}pass{{else}}fail{{end}}">{{if .Equal}}一致{{else}}不一致{{end}}</span></h3>
+<table><tr><th>源集群</th><th>目标集群</th></tr>
+<tr><td>{{range .Src}}<pre>{{printf "%s" .}}</pre>{{end}}</td><td>{{range .Dst}}<pre>{{printf "%s" .}}</pre>{{end}}</td></tr></table>{{end}}
+{{end}}
+
+<h2>运行参数</h2>
+<table>{{range $name, $value := .Params}}<tr><th>{{$name}}</th><td><code>{{$value}}</code></td></tr>{{end}}</table>
+</body>
+</html>
+`))
+
+// htmlReport 将报告渲染成自包含的 HTML 页面
+func htmlReport(report *runReport) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, report); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
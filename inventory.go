@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collSpec 是 listCollections 返回的集合信息
+type collSpec struct {
+	typ     string // collection|view|timeseries
+	options bson.Raw
+}
+
+// listCollectionSpecs 返回库中每个集合的类型和创建参数
+func listCollectionSpecs(database *mongo.Database) (map[string]collSpec, error) {
+	cursor, err := database.ListCollections(context.Background(), bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	specs := make(map[string]collSpec)
+	for cursor.Next(context.Background()) {
+		spec := collSpec{typ: "collection"}
+		if typ, ok := cursor.Current.Lookup("type").StringValueOK(); ok {
+			spec.typ = typ
+		}
+		if options, ok := cursor.Current.Lookup("options").DocumentOK(); ok {
+			spec.options = append(bson.Raw(nil), options...)
+		}
+		specs[cursor.Current.Lookup("name").StringValue()] = spec
+	}
+	return specs, cursor.Err()
+}
+
+// inventoryDiff 是两边命名空间清单的差异, 源集合按 nsMap 映射后和目标集合比较
+type inventoryDiff struct {
+	OnlyInSrc     []string        `json:"onlyInSrc"`          // 只在源集群存在的集合
+	OnlyInDst     []string        `json:"onlyInDst"`          // 只在目标集群存在的集合
+	DBsOnlyInDst  []string        `json:"databasesOnlyInDst"` // 只在目标集群存在的库, 只在 allDatabases 时检查
+	TypeDiffers   []nsTypeDiff    `json:"typeDiffers"`
+	OptionsDiffer []nsOptionsDiff `json:"optionsDiffer"`
+}
+
+type nsTypeDiff struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	SrcType string `json:"srcType"`
+	DstType string `json:"dstType"`
+}
+
+type nsOptionsDiff struct {
+	Src        string          `json:"src"`
+	Dst        string          `json:"dst"`
+	SrcOptions json.RawMessage `json:"srcOptions"`
+	DstOptions json.RawMessage `json:"dstOptions"`
+}
+
+// empty 返回两边的命名空间是否一致
+func (d *inventoryDiff) empty() bool {
+	return len(d.OnlyInSrc) == 0 && len(d.OnlyInDst) == 0 && len(d.DBsOnlyInDst) == 0 &&
+		len(d.TypeDiffers) == 0 && len(d.OptionsDiffer) == 0
+}
+
+// lines 返回每处差异的描述, 用于日志和报告
+func (d *inventoryDiff) lines() []string {
+	var lines []string
+	for _, ns := range d.OnlyInSrc {
+		lines = append(lines, "只在源集群存在的集合: "+ns)
+	}
+	for _, ns := range d.OnlyInDst {
+		lines = append(lines, "只在目标集群存在的集合: "+ns)
+	}
+	for _, name := range d.DBsOnlyInDst {
+		lines = append(lines, "只在目标集群存在的库: "+name)
+	}
+	for _, t := range d.TypeDiffers {
+		lines = append(lines, fmt.Sprintf("集合类型不一致: %s(%s) -> %s(%s)", t.Src, t.SrcType, t.Dst, t.DstType))
+	}
+	for _, o := range d.OptionsDiffer {
+		lines = append(lines, fmt.Sprintf("集合参数不一致: %s %s -> %s %s", o.Src, o.SrcOptions, o.Dst, o.DstOptions))
+	}
+	return lines
+}
+
+// specLister 返回库中每个集合的类型和创建参数
+type specLister func(dbName string) (map[string]collSpec, error)
+
+// compareInventory 比较两边的命名空间清单, 列出只在一边存在的集合和库、类型不同以及创建参数不同的集合
+// dbNames 是要检查的源集群库, namespaces 是其中选中的集合; scanDst 为 false 时(指定了 coll)不查找只在目标集群存在的集合
+func compareInventory(srcClient *mongo.Client, dstClient *mongo.Client, dbNames []string, namespaces []namespace, scanDst bool) (*inventoryDiff, error) {
+	srcSpecs := func(dbName string) (map[string]collSpec, error) {
+		specs, err := listCollectionSpecs(srcClient.Database(dbName))
+		if err != nil {
+			return nil, fmt.Errorf("源集群获取库 %s 的集合信息失败: %v", dbName, err)
+		}
+		return specs, nil
+	}
+	dstSpecs := func(dbName string) (map[string]collSpec, error) {
+		specs, err := listCollectionSpecs(dstClient.Database(dbName))
+		if err != nil {
+			return nil, fmt.Errorf("目标集群获取库 %s 的集合信息失败: %v", dbName, err)
+		}
+		return specs, nil
+	}
+	dstDBNames := func() ([]string, error) {
+		names, err := dstClient.ListDatabaseNames(context.Background(), bson.M{})
+		if err != nil {
+			return nil, fmt.Errorf("目标集群获取数据库列表失败: %v", err)
+		}
+		return names, nil
+	}
+	diff, err := diffInventory(dbNames, namespaces, scanDst, srcSpecs, dstSpecs, dstDBNames)
+	if err != nil {
+		return nil, err
+	}
+	if diff.empty() {
+		log.Printf("两边的命名空间一致")
+	} else {
+		log.Printf("两边的命名空间不一致:\n    %s", strings.Join(diff.lines(), "\n    "))
+	}
+	return diff, nil
+}
+
+// diffInventory 按 srcSpecs 和 dstSpecs 读取两边的集合信息并比较, 开启 allDatabases 时通过 dstDBNames 查找只在目标集群存在的库
+func diffInventory(dbNames []string, namespaces []namespace, scanDst bool, srcSpecs specLister, dstSpecs specLister, dstDBNames func() ([]string, error)) (*inventoryDiff, error) {
+	diff := &inventoryDiff{
+		OnlyInSrc:     []string{},
+		OnlyInDst:     []string{},
+		DBsOnlyInDst:  []string{},
+		TypeDiffers:   []nsTypeDiff{},
+		OptionsDiffer: []nsOptionsDiff{},
+	}
+	dstCache := make(map[string]map[string]collSpec)
+	dstSpecsOf := func(dbName string) (map[string]collSpec, error) {
+		if specs, ok := dstCache[dbName]; ok {
+			return specs, nil
+		}
+		specs, err := dstSpecs(dbName)
+		if err != nil {
+			return nil, err
+		}
+		dstCache[dbName] = specs
+		return specs, nil
+	}
+
+	selected := make(map[namespace]bool, len(namespaces))
+	for _, ns := range namespaces {
+		selected[ns] = true
+	}
+	// 所有源集合映射后的目标命名空间, 包括没有选中的集合, 这些目标集合不算只在目标集群存在
+	mapped := make(map[string]bool)
+	dstDBs := make(map[string]bool)
+	for _, dbName := range dbNames {
+		specs, err := srcSpecs(dbName)
+		if err != nil {
+			return nil, err
+		}
+		dstDBs[dbName] = true
+		for collName, srcSpec := range specs {
+			dstDBName, dstCollName, err := mapNamespace(dbName, collName)
+			if err != nil {
+				continue
+			}
+			dstNS := dstDBName + "." + dstCollName
+			mapped[dstNS] = true
+			dstDBs[dstDBName] = true
+			srcNS := namespace{db: dbName, coll: collName}
+			if !selected[srcNS] {
+				continue
+			}
+
+			specs, err := dstSpecsOf(dstDBName)
+			if err != nil {
+				return nil, err
+			}
+			dstSpec, ok := specs[dstCollName]
+			switch {
+			case !ok:
+				diff.OnlyInSrc = append(diff.OnlyInSrc, srcNS.String())
+			case srcSpec.typ != dstSpec.typ:
+				diff.TypeDiffers = append(diff.TypeDiffers, nsTypeDiff{Src: srcNS.String(), Dst: dstNS, SrcType: srcSpec.typ, DstType: dstSpec.typ})
+			case !bytes.Equal(srcSpec.options, dstSpec.options):
+				srcOptions, _ := bson.MarshalExtJSON(srcSpec.options, false, false)
+				dstOptions, _ := bson.MarshalExtJSON(dstSpec.options, false, false)
+				diff.OptionsDiffer = append(diff.OptionsDiffer, nsOptionsDiff{Src: srcNS.String(), Dst: dstNS, SrcOptions: srcOptions, DstOptions: dstOptions})
+			}
+		}
+	}
+
+	if scanDst {
+		if *allDatabases {
+			names, err := dstDBNames()
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if !systemDatabases[name] && !dstDBs[name] {
+					diff.DBsOnlyInDst = append(diff.DBsOnlyInDst, name)
+					dstDBs[name] = true
+				}
+			}
+		}
+		for dbName := range dstDBs {
+			specs, err := dstSpecsOf(dbName)
+			if err != nil {
+				return nil, err
+			}
+			for collName := range specs {
+				ns := namespace{db: dbName, coll: collName}
+				if !mapped[ns.String()] && ns.selected() {
+					diff.OnlyInDst = append(diff.OnlyInDst, ns.String())
+				}
+			}
+		}
+	}
+
+	sort.Strings(diff.OnlyInSrc)
+	sort.Strings(diff.OnlyInDst)
+	sort.Strings(diff.DBsOnlyInDst)
+	sort.Slice(diff.TypeDiffers, func(i, j int) bool { return diff.TypeDiffers[i].Src < diff.TypeDiffers[j].Src })
+	sort.Slice(diff.OptionsDiffer, func(i, j int) bool { return diff.OptionsDiffer[i].Src < diff.OptionsDiffer[j].Src })
+	return diff, nil
+}
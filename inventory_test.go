@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fakeSpecs 返回从固定数据读取集合信息的 specLister, 不存在的库返回空的集合列表
+func fakeSpecs(specs map[string]map[string]collSpec) specLister {
+	return func(dbName string) (map[string]collSpec, error) {
+		if dbName == "broken" {
+			return nil, errors.New("listCollections failed")
+		}
+		return specs[dbName], nil
+	}
+}
+
+func TestDiffInventory(t *testing.T) {
+	capped := marshalDoc(t, bson.D{{Key: "capped", Value: true}, {Key: "size", Value: int32(4096)}})
+	larger := marshalDoc(t, bson.D{{Key: "capped", Value: true}, {Key: "size", Value: int32(8192)}})
+	src := map[string]map[string]collSpec{
+		"db1": {
+			"users":  {typ: "collection"},
+			"orders": {typ: "collection", options: capped},
+			"v":      {typ: "view"},
+			"gone":   {typ: "collection"},
+			"tmp":    {typ: "collection"},
+		},
+	}
+	dst := map[string]map[string]collSpec{
+		"db1": {
+			"users":  {typ: "collection"},
+			"orders": {typ: "collection", options: larger},
+			"v":      {typ: "collection"},
+			"tmp":    {typ: "collection"},
+			"extra":  {typ: "collection"},
+		},
+		"core":  {"accounts": {typ: "collection"}},
+		"db3":   {"other": {typ: "collection"}},
+		"admin": {"system.users": {typ: "collection"}},
+	}
+	dbNames := func() ([]string, error) { return []string{"admin", "core", "db1", "db3", "local"}, nil }
+	// tmp 没有选中, 但它映射的目标集合也不算只在目标集群存在
+	namespaces := []namespace{{"db1", "users"}, {"db1", "orders"}, {"db1", "v"}, {"db1", "gone"}}
+
+	tests := []struct {
+		name         string
+		scanDst      bool
+		allDatabases bool
+		nsMap        []string
+		want         inventoryDiff
+	}{
+		{
+			name: "without scanning the destination",
+			want: inventoryDiff{
+				OnlyInSrc:     []string{"db1.gone"},
+				OnlyInDst:     []string{},
+				DBsOnlyInDst:  []string{},
+				TypeDiffers:   []nsTypeDiff{{Src: "db1.v", Dst: "db1.v", SrcType: "view", DstType: "collection"}},
+				OptionsDiffer: []nsOptionsDiff{{Src: "db1.orders", Dst: "db1.orders", SrcOptions: json.RawMessage(`{"capped":true,"size":4096}`), DstOptions: json.RawMessage(`{"capped":true,"size":8192}`)}},
+			},
+		},
+		{
+			name:    "scan destination databases of the source",
+			scanDst: true,
+			want: inventoryDiff{
+				OnlyInSrc:     []string{"db1.gone"},
+				OnlyInDst:     []string{"db1.extra"},
+				DBsOnlyInDst:  []string{},
+				TypeDiffers:   []nsTypeDiff{{Src: "db1.v", Dst: "db1.v", SrcType: "view", DstType: "collection"}},
+				OptionsDiffer: []nsOptionsDiff{{Src: "db1.orders", Dst: "db1.orders", SrcOptions: json.RawMessage(`{"capped":true,"size":4096}`), DstOptions: json.RawMessage(`{"capped":true,"size":8192}`)}},
+			},
+		},
+		{
+			name:         "all databases skips system databases",
+			scanDst:      true,
+			allDatabases: true,
+			want: inventoryDiff{
+				OnlyInSrc:     []string{"db1.gone"},
+				OnlyInDst:     []string{"core.accounts", "db1.extra", "db3.other"},
+				DBsOnlyInDst:  []string{"core", "db3"},
+				TypeDiffers:   []nsTypeDiff{{Src: "db1.v", Dst: "db1.v", SrcType: "view", DstType: "collection"}},
+				OptionsDiffer: []nsOptionsDiff{{Src: "db1.orders", Dst: "db1.orders", SrcOptions: json.RawMessage(`{"capped":true,"size":4096}`), DstOptions: json.RawMessage(`{"capped":true,"size":8192}`)}},
+			},
+		},
+		{
+			name:         "mapped namespaces",
+			scanDst:      true,
+			allDatabases: true,
+			nsMap:        []string{"db1.users:core.accounts", "db1.v:db1.orders"},
+			want: inventoryDiff{
+				OnlyInSrc:     []string{"db1.gone"},
+				OnlyInDst:     []string{"db1.extra", "db1.users", "db1.v", "db3.other"},
+				DBsOnlyInDst:  []string{"db3"},
+				TypeDiffers:   []nsTypeDiff{{Src: "db1.v", Dst: "db1.orders", SrcType: "view", DstType: "collection"}},
+				OptionsDiffer: []nsOptionsDiff{{Src: "db1.orders", Dst: "db1.orders", SrcOptions: json.RawMessage(`{"capped":true,"size":4096}`), DstOptions: json.RawMessage(`{"capped":true,"size":8192}`)}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, allDatabases, tt.allDatabases)
+			setFlag(t, &includePatterns, nil)
+			setFlag(t, &excludePatterns, nil)
+			setFlag(t, &nsRules, nil)
+			if err := loadNSRules(tt.nsMap); err != nil {
+				t.Fatal(err)
+			}
+			got, err := diffInventory([]string{"db1"}, namespaces, tt.scanDst, fakeSpecs(src), fakeSpecs(dst), dbNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("diffInventory =\n%s\nwant\n%s", gotJSON, wantJSON)
+			}
+			if got.empty() {
+				t.Errorf("empty() = true, want false")
+			}
+		})
+	}
+}
+
+func TestDiffInventoryErrors(t *testing.T) {
+	setFlag(t, allDatabases, true)
+	setFlag(t, &nsRules, nil)
+	specs := fakeSpecs(map[string]map[string]collSpec{"db1": {"users": {typ: "collection"}}})
+	ok := func() ([]string, error) { return []string{"db1"}, nil }
+	failed := func() ([]string, error) { return nil, errors.New("listDatabases failed") }
+	if _, err := diffInventory([]string{"broken"}, nil, true, specs, specs, ok); err == nil {
+		t.Errorf("source listCollections error should be returned")
+	}
+	if _, err := diffInventory([]string{"db1"}, nil, true, specs, specs, failed); err == nil {
+		t.Errorf("destination listDatabases error should be returned")
+	}
+	if err := loadNSRules([]string{"db1.*:broken.*"}); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := diffInventory([]string{"db1"}, []namespace{{"db1", "users"}}, false, specs, specs, ok)
+	if err == nil || diff != nil {
+		t.Errorf("destination listCollections: diffInventory = %v, %v, want error", diff, err)
+	}
+}
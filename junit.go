@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// junitTestSuites 是 JUnit XML 报告的根节点, 每个集合是一个 testcase
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitProblem 是 failure 或者 error 节点
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport 将报告转换成 JUnit XML, 数据不一致记为 failure, 检查出错记为 error
+func junitReport(report *runReport) ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      "mongocheck",
+		Tests:     len(report.Collections),
+		Time:      fmt.Sprintf("%.3f", report.Duration),
+		Timestamp: report.StartTime.Format("2006-01-02T15:04:05"),
+	}
+	names := make([]string, 0, len(report.Params))
+	for name := range report.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		suite.Properties = append(suite.Properties, junitProperty{Name: name, Value: report.Params[name]})
+	}
+	suite.Properties = append(suite.Properties,
+		junitProperty{Name: "srcVersion", Value: report.Versions.Src},
+		junitProperty{Name: "dstVersion", Value: report.Versions.Dst})
+
+	for _, coll := range report.Collections {
+		dbName, collName := coll.NS, coll.NS
+		if i := strings.Index(coll.NS, "."); i >= 0 {
+			dbName, collName = coll.NS[:i], coll.NS[i+1:]
+		}
+		testCase := junitTestCase{
+			Name:      collName,
+			ClassName: dbName,
+			Time:      fmt.Sprintf("%.3f", coll.Duration),
+			SystemOut: coll.summary(),
+		}
+		if n := coll.mismatchCount(); n > 0 && coll.Verdict == verdictKeys[verdictFail] {
+			suite.Failures++
+			lines := make([]string, 0, len(coll.Mismatches)+1)
+			for _, m := range coll.Mismatches {
+				lines = append(lines, m.String())
+			}
+			if coll.MismatchesOmitted > 0 {
+				lines = append(lines, fmt.Sprintf("... 省略 %d 条", coll.MismatchesOmitted))
+			}
+			testCase.Failure = &junitProblem{
+				Message: fmt.Sprintf("发现 %d 处不一致", n),
+				Type:    "mismatch",
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		if len(coll.Errors) > 0 {
+			suite.Errors++
+			testCase.Error = &junitProblem{
+				Message: coll.Errors[0],
+				Type:    "error",
+				Text:    strings.Join(coll.Errors, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	// 开启 checkNamespaces 时命名空间清单的比对作为单独的 testcase
+	if inv := report.Namespaces; inv != nil {
+		suite.Tests++
+		testCase := junitTestCase{Name: "namespaces", ClassName: "mongocheck", Time: "0.000"}
+		if lines := inv.lines(); len(lines) > 0 {
+			suite.Failures++
+			testCase.Failure = &junitProblem{
+				Message: fmt.Sprintf("命名空间存在 %d 处差异", len(lines)),
+				Type:    "namespaces",
+				Text:    strings.Join(lines, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// mismatchCount 返回集合的不一致总数
+func (c collReport) mismatchCount() int64 {
+	n := int64(0)
+	for _, count := range c.Findings {
+		n += count
+	}
+	return n
+}
+
+// summary 返回按类别统计的结果以及结论的原因
+func (c collReport) summary() string {
+	parts := []string{"verdict=" + c.Verdict, "mode=" + c.Mode, fmt.Sprintf("sampleSize=%d", c.SampleSize), fmt.Sprintf("compared=%d", c.Compared), fmt.Sprintf("same=%d", c.Same)}
+	for _, key := range findingKeys {
+		parts = append(parts, fmt.Sprintf("%s=%d", key, c.Findings[key]))
+	}
+	if c.Converged != nil {
+		parts = append(parts, fmt.Sprintf("converged=%d", c.Converged.Count))
+	}
+	if c.SnapshotFallback {
+		parts = append(parts, "snapshotFallback=true")
+	}
+	return strings.Join(append([]string{strings.Join(parts, " ")}, c.Reasons...), "\n")
+}
+
+// String 返回一行不一致明细, 内容不一致时附带每处差异
+func (f findingReport) String() string {
+	s := fmt.Sprintf("%s _id=%s", f.Kind, f.ID)
+	for _, op := range f.Patch {
+		s += "\n    " + op.String()
+	}
+	return s
+}
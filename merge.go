@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// checkCollectionByMergeJoin 两边都按 _id 升序打开游标, 像归并排序一样同时推进两个游标进行全量比对
+// 不需要逐条到目标集群查询, 并且可以发现只在目标集群存在的文档
+// 排序结果需要和 compareValues 一致, 所以要求 _id 上没有使用非 simple 的 collation
+func checkCollectionByMergeJoin(task *checkTask) error {
+	srcColl, dstColl := task.srcColl, task.dstColl
+	srcCount, err := sampleCount(srcColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取源集合 %s 文档数失败: %v", collNS(srcColl), err)
+	}
+
+	dstCount, err := sampleCount(dstColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取目标集合 %s 文档数失败: %v", collNS(dstColl), err)
+	}
+
+	log.Printf("开始比对集合:%s, 源集群文档数:%d, 目标集群文档数:%d, 比对方式: 按 _id 排序归并",
+		collNS(srcColl), srcCount, dstCount)
+
+	task.result.plan("merge", forward, srcCount)
+	if err := mergeJoin(context.Background(), task, bson.M{}, srcCount); err != nil {
+		return err
+	}
+
+	result := task.result
+	log.Printf("集合 %s 全量比对完成, 一致 %d 条, 仅源集群存在 %d 条, 仅目标集群存在 %d 条, 内容不一致 %d 条",
+		collNS(srcColl), result.same.Load(), result.findings[findingMissing].Load(), result.findings[findingExtra].Load(), result.contentDiffers())
+	return nil
+}
+
+// mergeJoin 对两边满足 cond 以及 filter 参数的文档按 _id 排序归并比对, 逐条打印发现的不一致并记录到 task.result
+// 归并比对本身就是全量比对, 发现不一致后继续比对, 只有达到 maxMismatches 时才中断
+// total 为预期的源文档数, 用于打印进度, 为 0 时不打印
+func mergeJoin(ctx context.Context, task *checkTask, cond bson.M, total int64) error {
+	srcColl, dstColl := task.srcColl, task.dstColl
+	filter := task.cmp.match(cond)
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if task.cmp.projection != nil {
+		findOptions.SetProjection(task.cmp.projection)
+	}
+	srcCtx, srcEnd := readContext(ctx, srcColl)
+	defer srcEnd()
+	srcCursor, err := srcColl.Find(srcCtx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("获取源集合 %s 数据失败: %v", collNS(srcColl), err)
+	}
+	defer srcCursor.Close(context.Background())
+	dstCtx, dstEnd := readContext(ctx, dstColl)
+	defer dstEnd()
+	dstCursor, err := dstColl.Find(dstCtx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("获取目标集合 %s 数据失败: %v", collNS(dstColl), err)
+	}
+	defer dstCursor.Close(context.Background())
+
+	result := task.result
+	scanned := int64(0)
+	progres := int64(0)
+	srcOK := srcCursor.Next(srcCtx)
+	dstOK := dstCursor.Next(dstCtx)
+	for srcOK || dstOK {
+		cmp := 0
+		switch {
+		case !dstOK:
+			cmp = -1
+		case !srcOK:
+			cmp = 1
+		default:
+			cmp = compareValues(srcCursor.Current.Lookup("_id"), dstCursor.Current.Lookup("_id"))
+		}
+
+		var err error
+		switch {
+		case cmp < 0:
+			log.Printf("目标集合 %s 没有对应的数据, _id:%v", collNS(dstColl), srcCursor.Current.Lookup("_id").String())
+			err = result.record(finding{kind: findingMissing, id: srcCursor.Current.Lookup("_id")})
+		case cmp > 0:
+			log.Printf("源集合 %s 没有对应的数据, 目标集合多余数据 _id:%v", collNS(srcColl), dstCursor.Current.Lookup("_id").String())
+			err = result.record(finding{kind: findingExtra, id: dstCursor.Current.Lookup("_id")})
+		default:
+			if task.cmp.equal(srcCursor.Current, dstCursor.Current) {
+				result.same.Add(1)
+			} else {
+				id := srcCursor.Current.Lookup("_id")
+				err = result.recordMismatch(id, task.cmp.reportMismatch(id, srcCursor.Current, dstCursor.Current))
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if cmp <= 0 {
+			srcOK = srcCursor.Next(srcCtx)
+			scanned++
+			if total > 0 && (scanned*100/total) > progres {
+				progres = scanned * 100 / total
+				log.Printf("集合 %s 比对数据 %d 条, 进度: %d%%", collNS(srcColl), scanned, progres)
+			}
+		}
+		if cmp >= 0 {
+			dstOK = dstCursor.Next(dstCtx)
+		}
+	}
+	if err := srcCursor.Err(); err != nil {
+		return fmt.Errorf("获取源集合 %s 数据失败: %v", collNS(srcColl), err)
+	}
+	if err := dstCursor.Err(); err != nil {
+		return fmt.Errorf("获取目标集合 %s 数据失败: %v", collNS(dstColl), err)
+	}
+	return nil
+}
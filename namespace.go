@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// systemDatabases 是 allDatabases 时默认跳过的系统库
+var systemDatabases = map[string]bool{"admin": true, "local": true, "config": true}
+
+// namespace 是一个要检查的源集合
+type namespace struct {
+	db   string
+	coll string
+}
+
+func (n namespace) String() string {
+	return n.db + "." + n.coll
+}
+
+// nsPattern 是 include/exclude 中的一个命名空间匹配规则, 匹配完整的 "库名.集合名"
+type nsPattern struct {
+	spec string
+	re   *regexp.Regexp
+}
+
+// parseNSPatterns 解析命名空间匹配规则, /正则/ 为正则, 否则为通配, * 匹配任意字符, ? 匹配单个字符
+func parseNSPatterns(specs []string) ([]nsPattern, error) {
+	patterns := make([]nsPattern, 0, len(specs))
+	for _, spec := range specs {
+		var expr string
+		if len(spec) > 1 && strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") {
+			expr = "^(?:" + spec[1:len(spec)-1] + ")$"
+		} else {
+			expr = regexp.QuoteMeta(spec)
+			expr = strings.ReplaceAll(expr, `\*`, ".*")
+			expr = strings.ReplaceAll(expr, `\?`, ".")
+			expr = "^" + expr + "$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("命名空间规则 %s 错误: %v", spec, err)
+		}
+		patterns = append(patterns, nsPattern{spec: spec, re: re})
+	}
+	return patterns, nil
+}
+
+// includePatterns 和 excludePatterns 是 include 和 exclude 参数解析后的规则
+var includePatterns, excludePatterns []nsPattern
+
+// matchAny 返回 ns 是否匹配任意一个规则
+func matchAny(patterns []nsPattern, ns string) bool {
+	for _, p := range patterns {
+		if p.re.MatchString(ns) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected 返回集合是否需要检查: system.* 集合总是跳过, 指定了 include 时需要匹配其中一个规则, 并且不能匹配 exclude 中的规则
+func (n namespace) selected() bool {
+	if strings.HasPrefix(n.coll, "system.") {
+		return false
+	}
+	if len(includePatterns) > 0 && !matchAny(includePatterns, n.String()) {
+		return false
+	}
+	return !matchAny(excludePatterns, n.String())
+}
+
+// selectDatabases 返回要检查的源集群数据库, allDatabases 时枚举所有库并跳过系统库, 否则使用 db 参数中的库
+func selectDatabases(client *mongo.Client) ([]string, error) {
+	if !*allDatabases {
+		dbNames := splitList(*db)
+		for _, dbName := range dbNames {
+			if !hasDatabase(client, dbName) {
+				return nil, fmt.Errorf("源集群数据库 %s 不存在", dbName)
+			}
+		}
+		return dbNames, nil
+	}
+
+	names, err := client.ListDatabaseNames(context.Background(), bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("源集群获取数据库列表失败: %v", err)
+	}
+	dbNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if !systemDatabases[name] {
+			dbNames = append(dbNames, name)
+		}
+	}
+	sort.Strings(dbNames)
+	return dbNames, nil
+}
+
+// selectNamespaces 枚举 dbNames 中的集合, 返回按命名空间排序的需要检查的集合
+func selectNamespaces(client *mongo.Client, dbNames []string) ([]namespace, error) {
+	var namespaces []namespace
+	skipped := 0
+	for _, dbName := range dbNames {
+		collNames, err := client.Database(dbName).ListCollectionNames(context.Background(), bson.M{})
+		if err != nil {
+			return nil, fmt.Errorf("源集群获取库 %s 的集合列表失败: %v", dbName, err)
+		}
+		for _, collName := range collNames {
+			if ns := (namespace{db: dbName, coll: collName}); ns.selected() {
+				namespaces = append(namespaces, ns)
+			} else {
+				skipped++
+			}
+		}
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].String() < namespaces[j].String()
+	})
+	log.Printf("源集群共 %d 个库, 需要检查 %d 个集合, 按 include/exclude 以及 system.* 跳过 %d 个集合", len(dbNames), len(namespaces), skipped)
+	return namespaces, nil
+}
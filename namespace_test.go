@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestParseNSPatternsErrors(t *testing.T) {
+	for _, spec := range []string{"/(/", "/[a-/"} {
+		if _, err := parseNSPatterns([]string{"db1.*", spec}); err == nil {
+			t.Errorf("parseNSPatterns(%q) should fail", spec)
+		}
+	}
+}
+
+func TestNamespaceSelected(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		ns      namespace
+		want    bool
+	}{
+		{name: "no rules", ns: namespace{"db1", "users"}, want: true},
+		{name: "system collection", ns: namespace{"db1", "system.views"}, want: false},
+		{name: "system collection included", include: []string{"db1.*"}, ns: namespace{"db1", "system.js"}, want: false},
+		{name: "wildcard include", include: []string{"db1.*"}, ns: namespace{"db1", "users"}, want: true},
+		{name: "wildcard include other db", include: []string{"db1.*"}, ns: namespace{"db2", "users"}, want: false},
+		{name: "wildcard is anchored", include: []string{"db1.user"}, ns: namespace{"db1", "users"}, want: false},
+		{name: "question mark", include: []string{"db?.users"}, ns: namespace{"db2", "users"}, want: true},
+		{name: "question mark matches one character", include: []string{"db?.users"}, ns: namespace{"db10", "users"}, want: false},
+		{name: "dot is literal", include: []string{"db1.users"}, ns: namespace{"db1x", "users"}, want: false},
+		{name: "regex include", include: []string{`/db\d+\.orders_.*/`}, ns: namespace{"db12", "orders_2024"}, want: true},
+		{name: "regex is anchored", include: []string{`/orders/`}, ns: namespace{"db1", "orders"}, want: false},
+		{name: "any include matches", include: []string{"db2.*", "db1.users"}, ns: namespace{"db1", "users"}, want: true},
+		{name: "exclude", exclude: []string{"*.tmp_*"}, ns: namespace{"db1", "tmp_1"}, want: false},
+		{name: "exclude wins over include", include: []string{"db1.*"}, exclude: []string{"db1.logs"}, ns: namespace{"db1", "logs"}, want: false},
+		{name: "exclude other", include: []string{"db1.*"}, exclude: []string{"db1.logs"}, ns: namespace{"db1", "users"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			include, err := parseNSPatterns(tt.include)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exclude, err := parseNSPatterns(tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			setFlag(t, &includePatterns, include)
+			setFlag(t, &excludePatterns, exclude)
+			if got := tt.ns.selected(); got != tt.want {
+				t.Errorf("%s selected = %v, want %v", tt.ns, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nsRule 是一条命名空间映射规则, 源命名空间 "库名.集合名" 完整匹配 pattern 时按 template 替换得到目标命名空间
+type nsRule struct {
+	spec     string
+	pattern  *regexp.Regexp
+	template string
+}
+
+// nsRules 是 nsMap 参数和配置文件中的映射规则, 按顺序使用第一条匹配的规则
+var nsRules []nsRule
+
+// parseNSRule 解析一条映射规则, 格式为 源:目标, 支持三种写法:
+// db1.users:core.accounts 精确映射; db1.*:db2.* 通配, * 匹配库名或者集合名, 目标中的 * 依次替换为源中匹配的内容;
+// /^app\.(.*)_v1$/:app_v2.$1 正则, 正则需要匹配完整的源命名空间, 目标中可以使用 $1 引用分组
+func parseNSRule(spec string) (nsRule, error) {
+	rule := nsRule{spec: spec}
+	if strings.HasPrefix(spec, "/") {
+		i := strings.LastIndex(spec, "/:")
+		if i <= 0 {
+			return rule, fmt.Errorf("映射规则 %s 格式错误, 正则规则的格式为 /正则/:目标", spec)
+		}
+		pattern, err := regexp.Compile("^(?:" + spec[1:i] + ")$")
+		if err != nil {
+			return rule, fmt.Errorf("映射规则 %s 的正则错误: %v", spec, err)
+		}
+		rule.pattern, rule.template = pattern, spec[i+2:]
+		return rule, nil
+	}
+
+	srcNS, dstNS, ok := strings.Cut(spec, ":")
+	if !ok || !strings.Contains(srcNS, ".") || !strings.Contains(dstNS, ".") {
+		return rule, fmt.Errorf("映射规则 %s 格式错误, 格式为 源库名.源集合名:目标库名.目标集合名", spec)
+	}
+	dbName, collName, _ := strings.Cut(srcNS, ".")
+	pattern := wildcardPattern(dbName, "[^.]*") + `\.` + wildcardPattern(collName, ".*")
+	groups := strings.Count(srcNS, "*")
+	if strings.Count(dstNS, "*") > groups {
+		return rule, fmt.Errorf("映射规则 %s 中目标的 * 多于源的 *", spec)
+	}
+	template := strings.ReplaceAll(dstNS, "$", "$$")
+	for i := 1; i <= groups; i++ {
+		template = strings.Replace(template, "*", "${"+strconv.Itoa(i)+"}", 1)
+	}
+	rule.pattern, rule.template = regexp.MustCompile("^"+pattern+"$"), template
+	return rule, nil
+}
+
+// wildcardPattern 将库名或者集合名中的 * 转换成正则分组, 其余部分按原文匹配
+func wildcardPattern(name string, group string) string {
+	parts := strings.Split(name, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return strings.Join(parts, "("+group+")")
+}
+
+// loadNSRules 解析 nsMap 参数和配置文件中的映射规则, 参数中的规则优先
+func loadNSRules(specs []string) error {
+	for _, spec := range specs {
+		rule, err := parseNSRule(spec)
+		if err != nil {
+			return err
+		}
+		nsRules = append(nsRules, rule)
+	}
+	return nil
+}
+
+// mapNamespace 返回源集合对应的目标库名和集合名, 没有匹配的规则时使用相同的库名和集合名
+func mapNamespace(dbName string, collName string) (string, string, error) {
+	ns := dbName + "." + collName
+	for _, rule := range nsRules {
+		match := rule.pattern.FindStringSubmatchIndex(ns)
+		if match == nil {
+			continue
+		}
+		dstNS := string(rule.pattern.ExpandString(nil, rule.template, ns, match))
+		dstDB, dstColl, ok := strings.Cut(dstNS, ".")
+		if !ok || dstDB == "" || dstColl == "" {
+			return "", "", fmt.Errorf("源集合 %s 按映射规则 %s 得到的目标 %s 不是合法的命名空间", ns, rule.spec, dstNS)
+		}
+		return dstDB, dstColl, nil
+	}
+	return dbName, collName, nil
+}
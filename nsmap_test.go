@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseNSRuleErrors(t *testing.T) {
+	specs := []string{
+		"db1.users",
+		"db1:db2.users",
+		"db1.users:db2",
+		"db1.*:db2.*.*",
+		"/abc",
+		"/(/:db.coll",
+	}
+	for _, spec := range specs {
+		if _, err := parseNSRule(spec); err == nil {
+			t.Errorf("parseNSRule(%q) should fail", spec)
+		}
+	}
+}
+
+func TestMapNamespace(t *testing.T) {
+	saved := nsRules
+	t.Cleanup(func() { nsRules = saved })
+	nsRules = nil
+	err := loadNSRules([]string{
+		"db1.users:core.accounts",
+		"db1.*:db2.*",
+		"*.logs_*:archive.*_*",
+		"db3.*:db4.price$*",
+		`/app\.(.*)_v1/:app_v2.$1`,
+		"/bad\\.(.*)/:$1",
+	})
+	if err != nil {
+		t.Fatalf("loadNSRules: %v", err)
+	}
+
+	tests := []struct {
+		db, coll         string
+		wantDB, wantColl string
+		wantErr          bool
+	}{
+		// 按顺序使用第一条匹配的规则
+		{db: "db1", coll: "users", wantDB: "core", wantColl: "accounts"},
+		{db: "db1", coll: "orders", wantDB: "db2", wantColl: "orders"},
+		// 集合名可以包含 "."
+		{db: "db1", coll: "a.b", wantDB: "db2", wantColl: "a.b"},
+		{db: "shop", coll: "logs_2024", wantDB: "archive", wantColl: "shop_2024"},
+		// 目标中的 $ 按原文保留
+		{db: "db3", coll: "x", wantDB: "db4", wantColl: "price$x"},
+		{db: "app", coll: "orders_v1", wantDB: "app_v2", wantColl: "orders"},
+		// 正则需要匹配完整的命名空间
+		{db: "app", coll: "orders_v1_old", wantDB: "app", wantColl: "orders_v1_old"},
+		{db: "other", coll: "users", wantDB: "other", wantColl: "users"},
+		// 映射结果不是 库名.集合名
+		{db: "bad", coll: "coll", wantErr: true},
+	}
+	for _, tt := range tests {
+		db, coll, err := mapNamespace(tt.db, tt.coll)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("mapNamespace(%s.%s) = %s.%s, want error", tt.db, tt.coll, db, coll)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mapNamespace(%s.%s): %v", tt.db, tt.coll, err)
+			continue
+		}
+		if db != tt.wantDB || coll != tt.wantColl {
+			t.Errorf("mapNamespace(%s.%s) = %s.%s, want %s.%s", tt.db, tt.coll, db, coll, tt.wantDB, tt.wantColl)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 全表分区扫描时打印进度的间隔
+const partitionProgressInterval = 10 * time.Second
+
+// idRange 表示 _id 的左闭右开区间 [min, max), Type 为 0 的边界表示不限
+// 和 skip 模式一样依赖 "一个表的 _id 类型是一样的" 这个假设, 因为 $gte/$lt 只会匹配同类型的值
+type idRange struct {
+	min bson.RawValue
+	max bson.RawValue
+}
+
+// filter 返回匹配该区间的查询条件
+func (r idRange) filter() bson.M {
+	cond := bson.M{}
+	if r.min.Type != 0 {
+		cond["$gte"] = r.min
+	}
+	if r.max.Type != 0 {
+		cond["$lt"] = r.max
+	}
+	if len(cond) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"_id": cond}
+}
+
+func (r idRange) String() string {
+	min, max := "MinKey", "MaxKey"
+	if r.min.Type != 0 {
+		min = r.min.String()
+	}
+	if r.max.Type != 0 {
+		max = r.max.String()
+	}
+	return fmt.Sprintf("[%s, %s)", min, max)
+}
+
+// idTypeBracket 返回 _id 在查询中的类型分组, 数值类型之间可以用 $gte/$lt 比较, 其他类型只和同类型的值比较
+func idTypeBracket(t bsontype.Type) bsontype.Type {
+	switch t {
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDecimal128:
+		return bson.TypeDouble
+	}
+	return t
+}
+
+// uniformIDType 返回所有集合的 _id 是否属于同一个类型分组, 只有这时按 _id 区间切分才能覆盖所有文档
+// $gte/$lt 只匹配和边界同类型分组的 _id, 而按 _id 排序时不同类型分组按固定顺序排列, 所以只需要比较每个集合最小和最大的 _id
+func uniformIDType(colls ...*mongo.Collection) (bool, error) {
+	bracket := bsontype.Type(0)
+	for _, coll := range colls {
+		for _, order := range []int{1, -1} {
+			id, err := boundaryID(coll, idRange{}, order)
+			if err != nil {
+				return false, err
+			}
+			if id.Type == 0 {
+				// 集合没有数据
+				continue
+			}
+			if bracket != 0 && idTypeBracket(id.Type) != bracket {
+				return false, nil
+			}
+			bracket = idTypeBracket(id.Type)
+		}
+	}
+	return true, nil
+}
+
+// splitIDRange 将集合在区间 r 内的 _id 切分成最多 n 个子区间
+// _id 为 ObjectId 时按其中的时间戳均匀切分, 只需要两次索引查询; 否则使用 $bucketAuto 按文档数均匀切分
+func splitIDRange(coll *mongo.Collection, r idRange, n int) ([]idRange, error) {
+	if n <= 1 {
+		return []idRange{r}, nil
+	}
+	boundaries, err := objectIDBoundaries(coll, r, n)
+	if err != nil {
+		return nil, err
+	}
+	if boundaries == nil {
+		boundaries, err = bucketAutoBoundaries(coll, r, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ranges := make([]idRange, 0, len(boundaries)+1)
+	lower := r.min
+	for _, b := range boundaries {
+		ranges = append(ranges, idRange{min: lower, max: b})
+		lower = b
+	}
+	return append(ranges, idRange{min: lower, max: r.max}), nil
+}
+
+// objectIDBoundaries 按 ObjectId 时间戳切分区间, _id 不是 ObjectId 或时间跨度太小时返回 nil
+func objectIDBoundaries(coll *mongo.Collection, r idRange, n int) ([]bson.RawValue, error) {
+	first, err := boundaryID(coll, r, 1)
+	if err != nil || first.Type != bson.TypeObjectID {
+		return nil, err
+	}
+	last, err := boundaryID(coll, r, -1)
+	if err != nil || last.Type != bson.TypeObjectID {
+		return nil, err
+	}
+
+	minTs := first.ObjectID().Timestamp().Unix()
+	maxTs := last.ObjectID().Timestamp().Unix()
+	if maxTs-minTs < int64(n) {
+		return nil, nil
+	}
+	boundaries := make([]bson.RawValue, 0, n-1)
+	for i := int64(1); i < int64(n); i++ {
+		ts := minTs + (maxTs-minTs)*i/int64(n)
+		oid := primitive.NewObjectIDFromTimestamp(time.Unix(ts, 0))
+		boundaries = append(boundaries, bson.RawValue{Type: bson.TypeObjectID, Value: oid[:]})
+	}
+	return boundaries, nil
+}
+
+// boundaryID 返回区间内最小(order=1)或最大(order=-1)的 _id, 区间内没有数据时返回空值
+func boundaryID(coll *mongo.Collection, r idRange, order int) (bson.RawValue, error) {
+	findOneOptions := options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: order}}).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+	doc, err := coll.FindOne(context.Background(), r.filter(), findOneOptions).Raw()
+	if err == mongo.ErrNoDocuments {
+		return bson.RawValue{}, nil
+	}
+	if err != nil {
+		return bson.RawValue{}, fmt.Errorf("获取集合 %s 区间 %s 的边界 _id 失败: %v", collNS(coll), r, err)
+	}
+	return doc.Lookup("_id"), nil
+}
+
+// bucketAutoBoundaries 使用 $bucketAuto 按文档数均匀切分区间
+func bucketAutoBoundaries(coll *mongo.Collection, r idRange, n int) ([]bson.RawValue, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: r.filter()}},
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$bucketAuto", Value: bson.D{{Key: "groupBy", Value: "$_id"}, {Key: "buckets", Value: n}}}},
+	}
+	cursor, err := coll.Aggregate(context.Background(), pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("集合 %s 使用 $bucketAuto 切分区间 %s 失败: %v", collNS(coll), r, err)
+	}
+	defer cursor.Close(context.Background())
+
+	boundaries := make([]bson.RawValue, 0, n-1)
+	first := true
+	for cursor.Next(context.Background()) {
+		// 每个桶的 min 是上一个桶的 max, 第一个桶的 min 保持区间原有的下界
+		if first {
+			first = false
+			continue
+		}
+		min := cursor.Current.Lookup("_id", "min")
+		boundaries = append(boundaries, bson.RawValue{Type: min.Type, Value: append([]byte(nil), min.Value...)})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("集合 %s 使用 $bucketAuto 切分区间 %s 失败: %v", collNS(coll), r, err)
+	}
+	return boundaries, nil
+}
+
+// partitionTask 记录单个分区的扫描进度
+type partitionTask struct {
+	r       idRange
+	scanned atomic.Int64
+	done    atomic.Bool
+}
+
+// checkCollectionByPartitions 将 _id 空间切分成 partitions 个区间, 每个区间由单独的 goroutine 全表扫描并比对
+// 定期打印每个分区的进度和整体的预计剩余时间
+func checkCollectionByPartitions(task *checkTask) error {
+	srcColl, dstColl := task.srcColl, task.dstColl
+	srcCount, err := sampleCount(srcColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取%s集合 %s 文档数失败: %v", task.srcSide(), collNS(srcColl), err)
+	}
+
+	dstCount, err := sampleCount(dstColl, task.cmp.filter)
+	if err != nil {
+		return fmt.Errorf("获取%s集合 %s 文档数失败: %v", task.dstSide(), collNS(dstColl), err)
+	}
+
+	task.result.plan("partitions", task.dir, srcCount)
+	if srcCount == 0 {
+		log.Printf("%s集合 %s 没有数据, 跳过检查", task.srcSide(), collNS(srcColl))
+		return nil
+	}
+
+	uniform, err := uniformIDType(srcColl)
+	if err != nil {
+		return err
+	}
+	ranges := []idRange{{}}
+	if uniform {
+		if ranges, err = splitIDRange(srcColl, idRange{}, *partitions); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("%s集合 %s 的 _id 包含多种类型, 无法按 _id 区间切分, 使用一个分区扫描", task.srcSide(), collNS(srcColl))
+	}
+	log.Printf("开始比对集合:%s, 方向:%s, %s集群文档数:%d, %s集群文档数:%d, 比对方式: 分区全表扫描, 分区数:%d",
+		collNS(srcColl), task.dir, task.srcSide(), srcCount, task.dstSide(), dstCount, len(ranges))
+
+	parts := make([]*partitionTask, len(ranges))
+	for i, r := range ranges {
+		parts[i] = &partitionTask{r: r}
+		log.Printf("集合 %s 分区 %d/%d: %s", collNS(srcColl), i+1, len(ranges), r)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	stopProgress := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(partitionProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopProgress:
+				return
+			case <-ticker.C:
+				logPartitionProgress(collNS(srcColl), parts, srcCount, start)
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part *partitionTask) {
+			defer wg.Done()
+			if err := scanPartition(ctx, task, part); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("集合 %s 分区 %d/%d %s 比对失败: %v", collNS(srcColl), i+1, len(parts), part.r, err)
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			log.Printf("集合 %s 分区 %d/%d 比对完成, 共 %d 条, 耗时: %v",
+				collNS(srcColl), i+1, len(parts), part.scanned.Load(), time.Since(start).Round(time.Second))
+		}(i, part)
+	}
+	wg.Wait()
+	close(stopProgress)
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	total := int64(0)
+	for _, part := range parts {
+		total += part.scanned.Load()
+	}
+	log.Printf("集合 %s 抽样数据成功 %d 条, 检查完成", collNS(srcColl), total)
+	return nil
+}
+
+// scanPartition 扫描单个分区并逐批比对
+func scanPartition(ctx context.Context, task *checkTask, partition *partitionTask) error {
+	defer partition.done.Store(true)
+	srcColl := task.srcColl
+
+	// 服务端对游标的所有 getMore 累计计算 maxTimeMS, 大分区的扫描时间很长, 所以不设置 MaxTime
+	findOptions := options.FindOptions{
+		Projection: task.cmp.findProjection(),
+	}
+	ctx, end := readContext(ctx, srcColl)
+	defer end()
+	srcCursor, err := srcColl.Find(ctx, task.cmp.match(partition.r.filter()), &findOptions)
+	if err != nil {
+		return fmt.Errorf("获取%s集合 %s 数据失败: %v", task.srcSide(), collNS(srcColl), err)
+	}
+	defer srcCursor.Close(context.Background())
+
+	checker := newDocChecker(task, 0)
+	for srcCursor.Next(ctx) {
+		if err := checker.add(srcCursor.Current); err != nil {
+			return err
+		}
+		partition.scanned.Add(1)
+	}
+	if err := srcCursor.Err(); err != nil {
+		return fmt.Errorf("获取%s集合 %s 数据失败: %v", task.srcSide(), collNS(srcColl), err)
+	}
+	return checker.flush()
+}
+
+// logPartitionProgress 打印每个未完成分区的进度, 以及按当前速度估算的整体剩余时间
+func logPartitionProgress(collName string, parts []*partitionTask, srcCount int64, start time.Time) {
+	scanned := int64(0)
+	for i, part := range parts {
+		n := part.scanned.Load()
+		scanned += n
+		if !part.done.Load() {
+			log.Printf("集合 %s 分区 %d/%d 已扫描 %d 条", collName, i+1, len(parts), n)
+		}
+	}
+	if scanned == 0 {
+		return
+	}
+
+	// 文档数是估算值, 进度可能会超过 100%
+	elapsed := time.Since(start)
+	remaining := time.Duration(0)
+	if scanned < srcCount {
+		remaining = time.Duration(float64(elapsed) * float64(srcCount-scanned) / float64(scanned))
+	}
+	log.Printf("集合 %s 分区全表扫描进度: %d/%d (%d%%), 已用时间: %v, 预计剩余时间: %v",
+		collName, scanned, srcCount, scanned*100/srcCount, elapsed.Round(time.Second), remaining.Round(time.Second))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verdict 是按策略评估后集合的检查结论
+type verdict int
+
+const (
+	verdictPass verdict = iota // 没有发现任何差异
+	verdictWarn                // 存在差异, 但在策略允许的范围内
+	verdictFail                // 差异超过策略允许的范围, 或者检查出错
+)
+
+// verdictKeys 是报告中使用的结论名
+var verdictKeys = [...]string{"pass", "warn", "fail"}
+
+var verdictNames = [...]string{"通过", "通过(在允许范围内)", "失败"}
+
+// evaluate 按集合的策略评估检查结果, 返回结论以及不是 pass 的原因
+// 检查出错和查询失败总是 fail; 缺失、多余和内容不一致的文档数按占比对文档数的比例和 missingRate/mismatchRate 比较;
+// 文档数和索引超出允许范围时已经记录为错误, 这里只处理允许范围内的差异
+func (r *collResult) evaluate() (verdict, []string) {
+	var reasons []string
+	v := verdictPass
+	raise := func(to verdict, format string, args ...interface{}) {
+		if to > v {
+			v = to
+		}
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+
+	r.mu.Lock()
+	errs := len(r.errs)
+	r.mu.Unlock()
+	if errs > 0 {
+		raise(verdictFail, "检查出错 %d 处", errs)
+	}
+	if n := r.findings[findingLookupError].Load(); n > 0 {
+		raise(verdictFail, "%d 条文档查询失败, 无法比对", n)
+	}
+
+	compared := r.compared()
+	rate := func(n int64) float64 {
+		if compared == 0 {
+			return 0
+		}
+		return float64(n) / float64(compared)
+	}
+	// continueNotExist 时目标集群缺失的文档只记录, 不影响结论, 和之前的版本一样退出码为 0
+	if n := r.findings[findingMissing].Load(); n > 0 && !*continueNotExist {
+		if rate(n) > *r.conf.MissingRate {
+			raise(verdictFail, "目标集群缺失 %d 条, 占比 %.4f%%, 超过允许的 %.4f%%", n, rate(n)*100, *r.conf.MissingRate*100)
+		} else {
+			raise(verdictWarn, "目标集群缺失 %d 条, 占比 %.4f%%, 在允许的 %.4f%% 以内", n, rate(n)*100, *r.conf.MissingRate*100)
+		}
+	}
+	if n := r.findings[findingExtra].Load() + r.contentDiffers(); n > 0 {
+		if rate(n) > *r.conf.MismatchRate {
+			raise(verdictFail, "不一致 %d 条, 占比 %.4f%%, 超过允许的 %.4f%%", n, rate(n)*100, *r.conf.MismatchRate*100)
+		} else {
+			raise(verdictWarn, "不一致 %d 条, 占比 %.4f%%, 在允许的 %.4f%% 以内", n, rate(n)*100, *r.conf.MismatchRate*100)
+		}
+	}
+	if n := r.findings[findingModified].Load(); n > 0 {
+		raise(verdictWarn, "检查期间被修改且复查后仍不一致 %d 条", n)
+	}
+
+	if c := r.counts; c != nil && c.src != c.dst {
+		if c.withinTolerance() {
+			raise(verdictWarn, "文档数相差 %d, 在允许的 %d 以内", c.dst-c.src, c.allowed)
+		} else if *countAction == "warn" {
+			raise(verdictWarn, "文档数相差 %d, 超过允许的 %d, countAction=warn", c.dst-c.src, c.allowed)
+		}
+	}
+	if r.indexes != nil && !r.indexes.equal && r.conf.IndexPolicy == "warn" {
+		raise(verdictWarn, "索引不一致, indexPolicy=warn")
+	}
+	return v, reasons
+}
+
+// tolerates 返回发现该类不一致后是否继续检查。策略允许一定比例的该类不一致时, 需要检查完才能计算比例;
+// 开启复查时, 需要检查完再复查
+func (r *collResult) tolerates(kind findingKind) bool {
+	switch {
+	case kind == findingLookupError:
+		return false
+	case recheckEnabled():
+		return true
+	}
+	switch kind {
+	case findingMissing:
+		return *continueNotExist || *r.conf.MissingRate > 0
+	}
+	return *r.conf.MismatchRate > 0
+}
+
+// failed 返回集合是否检查失败
+func (r *collResult) failed() bool {
+	v, _ := r.evaluate()
+	return v == verdictFail
+}
+
+// checkPolicy 检查策略参数是否合法, 用于命令行参数和配置文件
+func checkPolicy(name string, conf collConfig) error {
+	var invalid []string
+	if *conf.MismatchRate < 0 || *conf.MismatchRate > 1 {
+		invalid = append(invalid, "mismatchRate 必须在 0 到 1 之间")
+	}
+	if *conf.MissingRate < 0 || *conf.MissingRate > 1 {
+		invalid = append(invalid, "missingRate 必须在 0 到 1 之间")
+	}
+	if *conf.CountTolerance < 0 || *conf.CountToleranceRate < 0 {
+		invalid = append(invalid, "countTolerance 和 countToleranceRate 不能小于 0")
+	}
+	if conf.IndexPolicy != "fail" && conf.IndexPolicy != "warn" && conf.IndexPolicy != "ignore" {
+		invalid = append(invalid, "indexPolicy 必须为 fail|warn|ignore")
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%s 的策略不合法: %s", name, strings.Join(invalid, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// setFlag 在测试期间修改命令行参数的值, 测试结束后恢复
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	saved := *p
+	*p = v
+	t.Cleanup(func() { *p = saved })
+}
+
+// testResult 返回使用命令行参数默认策略的检查结果, same 条文档一致, findings 为各类不一致的文档数
+func testResult(same int64, findings map[findingKind]int64) *collResult {
+	r := &collResult{ns: "db1.coll", conf: collectionConfig("db1", "coll"), paths: make(map[string]int64)}
+	r.same.Store(same)
+	for kind, n := range findings {
+		r.findings[kind].Store(n)
+	}
+	return r
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name             string
+		same             int64
+		findings         map[findingKind]int64
+		err              error
+		missingRate      float64
+		mismatchRate     float64
+		continueNotExist bool
+		counts           *countComparison
+		countAction      string
+		indexes          *indexComparison
+		indexPolicy      string
+		want             verdict
+		reasons          int
+	}{
+		{name: "no difference", same: 100, want: verdictPass},
+		{name: "error", same: 100, err: errors.New("boom"), want: verdictFail, reasons: 1},
+		{name: "lookup error", same: 99, findings: map[findingKind]int64{findingLookupError: 1}, mismatchRate: 1, want: verdictFail, reasons: 1},
+		{name: "missing", same: 99, findings: map[findingKind]int64{findingMissing: 1}, want: verdictFail, reasons: 1},
+		{name: "missing within missingRate", same: 99, findings: map[findingKind]int64{findingMissing: 1}, missingRate: 0.01, want: verdictWarn, reasons: 1},
+		{name: "missing over missingRate", same: 98, findings: map[findingKind]int64{findingMissing: 2}, missingRate: 0.01, want: verdictFail, reasons: 1},
+		{name: "missing with continueNotExist", same: 99, findings: map[findingKind]int64{findingMissing: 1}, continueNotExist: true, want: verdictPass},
+		{name: "continueNotExist does not tolerate mismatches", same: 98, findings: map[findingKind]int64{findingMissing: 1, findingDiffer: 1}, continueNotExist: true, want: verdictFail, reasons: 1},
+		{name: "mismatch within mismatchRate", same: 98, findings: map[findingKind]int64{findingExtra: 1, findingTypeOnly: 1}, mismatchRate: 0.02, want: verdictWarn, reasons: 1},
+		{name: "mismatch over mismatchRate", same: 97, findings: map[findingKind]int64{findingDiffer: 2, findingFieldOrder: 1}, mismatchRate: 0.02, want: verdictFail, reasons: 1},
+		{name: "modified", same: 99, findings: map[findingKind]int64{findingModified: 1}, want: verdictWarn, reasons: 1},
+		{name: "count within tolerance", same: 100, counts: &countComparison{src: 100, dst: 98, allowed: 2}, want: verdictWarn, reasons: 1},
+		{name: "count over tolerance with countAction=warn", same: 100, counts: &countComparison{src: 100, dst: 90, allowed: 2}, countAction: "warn", want: verdictWarn, reasons: 1},
+		{name: "equal counts", same: 100, counts: &countComparison{src: 100, dst: 100}, want: verdictPass},
+		{name: "index differs with indexPolicy=warn", same: 100, indexes: &indexComparison{}, indexPolicy: "warn", want: verdictWarn, reasons: 1},
+		{name: "index differs with indexPolicy=ignore", same: 100, indexes: &indexComparison{}, indexPolicy: "ignore", want: verdictPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, missingRate, tt.missingRate)
+			setFlag(t, mismatchRate, tt.mismatchRate)
+			setFlag(t, continueNotExist, tt.continueNotExist)
+			if tt.countAction != "" {
+				setFlag(t, countAction, tt.countAction)
+			}
+			if tt.indexPolicy != "" {
+				setFlag(t, indexPolicy, tt.indexPolicy)
+			}
+			r := testResult(tt.same, tt.findings)
+			if tt.err != nil {
+				r.errs = append(r.errs, tt.err)
+			}
+			r.counts, r.indexes = tt.counts, tt.indexes
+			got, reasons := r.evaluate()
+			if got != tt.want || len(reasons) != tt.reasons {
+				t.Errorf("evaluate = %s %q, want %s with %d reasons", verdictKeys[got], reasons, verdictKeys[tt.want], tt.reasons)
+			}
+		})
+	}
+}
+
+func TestTolerates(t *testing.T) {
+	tests := []struct {
+		name             string
+		kind             findingKind
+		missingRate      float64
+		mismatchRate     float64
+		continueNotExist bool
+		recheck          int
+		want             bool
+	}{
+		{name: "missing", kind: findingMissing, want: false},
+		{name: "missing with missingRate", kind: findingMissing, missingRate: 0.1, want: true},
+		{name: "missing with continueNotExist", kind: findingMissing, continueNotExist: true, want: true},
+		{name: "missing with mismatchRate", kind: findingMissing, mismatchRate: 0.1, want: false},
+		{name: "differ", kind: findingDiffer, want: false},
+		{name: "differ with mismatchRate", kind: findingDiffer, mismatchRate: 0.1, want: true},
+		{name: "differ with continueNotExist", kind: findingDiffer, continueNotExist: true, want: false},
+		{name: "extra with recheck", kind: findingExtra, recheck: 3, want: true},
+		{name: "lookup error", kind: findingLookupError, mismatchRate: 1, recheck: 3, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, missingRate, tt.missingRate)
+			setFlag(t, mismatchRate, tt.mismatchRate)
+			setFlag(t, continueNotExist, tt.continueNotExist)
+			setFlag(t, recheckAttempts, tt.recheck)
+			if got := testResult(0, nil).tolerates(tt.kind); got != tt.want {
+				t.Errorf("tolerates(%s) = %v, want %v", findingKeys[tt.kind], got, tt.want)
+			}
+		})
+	}
+}
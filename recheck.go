@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// convergedFinding 是复查时已经一致的不一致, elapsed 为从发现到复查一致经过的时间
+type convergedFinding struct {
+	kind     findingKind
+	id       bson.RawValue
+	attempts int
+	elapsed  time.Duration
+}
+
+// recheckEnabled 返回是否需要复查不一致, 开启 watchChanges 时检查期间被修改的文档总是需要复查
+func recheckEnabled() bool {
+	return *recheckAttempts > 0 || *watchChanges
+}
+
+// recheckFindings 等待 recheckDelay 后到两边重新读取不一致的文档并比对, 最多 recheckAttempts 次
+// 重新比对一致(包括两边都已删除)的文档记为已收敛, 多次复查后仍然不一致的文档作为最终结果重新记录
+// 目标集群处于增量同步时, 可以过滤掉同步延迟导致的不一致
+// changes 不为 nil 时, 没有指定 recheckAttempts 也会复查一次检查期间被修改的文档, 复查后仍然不一致的记为检查期间被修改
+func recheckFindings(srcColl *mongo.Collection, dstColl *mongo.Collection, cmp *comparator, result *collResult, changes *changeTracker) {
+	pending := result.takePending()
+	attempts := *recheckAttempts
+	waited := false
+	if attempts == 0 && len(pending) > 0 {
+		// 只复查检查期间被修改的文档, change stream 的事件在多数派提交之后才会到达,
+		// 先等待 recheckDelay, 检查末尾几批文档的修改也能被记录, 再判断文档是否在检查期间被修改
+		time.Sleep(*recheckDelay)
+		waited = true
+		attempts = 1
+		touched := pending[:0]
+		for _, f := range pending {
+			if changes.touched(f.id) {
+				touched = append(touched, f)
+			} else {
+				result.restore(f)
+			}
+		}
+		pending = touched
+	}
+	if len(pending) == 0 {
+		return
+	}
+	log.Printf("集合 %s 开始复查 %d 处不一致, 间隔:%v, 最多 %d 次", result.ns, len(pending), *recheckDelay, attempts)
+	for attempt := 1; attempt <= attempts && len(pending) > 0; attempt++ {
+		if attempt > 1 || !waited {
+			time.Sleep(*recheckDelay)
+		}
+		var remaining []finding
+		for start := 0; start < len(pending); start += *batchSize {
+			end := start + *batchSize
+			if end > len(pending) {
+				end = len(pending)
+			}
+			batch := pending[start:end]
+			converged, err := recheckBatch(srcColl, dstColl, cmp, batch)
+			if err != nil {
+				log.Printf("集合 %s 第 %d 次复查失败, 下次继续复查: %v", result.ns, attempt, err)
+				remaining = append(remaining, batch...)
+				continue
+			}
+			for i, f := range batch {
+				if converged[i] {
+					result.converge(f, attempt)
+				} else {
+					remaining = append(remaining, f)
+				}
+			}
+		}
+		log.Printf("集合 %s 第 %d 次复查, 收敛 %d 处, 仍不一致 %d 处", result.ns, attempt, len(pending)-len(remaining), len(remaining))
+		pending = remaining
+	}
+	for _, f := range pending {
+		if changes != nil && changes.touched(f.id) {
+			f.kind = findingModified
+		}
+		result.restore(f)
+	}
+}
+
+// recheckBatch 到两边批量读取一批不一致的文档, 返回每条文档是否已经一致
+func recheckBatch(srcColl *mongo.Collection, dstColl *mongo.Collection, cmp *comparator, batch []finding) ([]bool, error) {
+	ids := make(bson.A, 0, len(batch))
+	for _, f := range batch {
+		ids = append(ids, f.id)
+	}
+	srcDocs, err := findByIDs(srcColl, cmp, ids)
+	if err != nil {
+		return nil, fmt.Errorf("获取源集合 %s 数据失败: %v", collNS(srcColl), err)
+	}
+	dstDocs, err := findByIDs(dstColl, cmp, ids)
+	if err != nil {
+		return nil, fmt.Errorf("获取目标集合 %s 数据失败: %v", collNS(dstColl), err)
+	}
+
+	converged := make([]bool, len(batch))
+	for i, f := range batch {
+		srcDoc, srcOK := srcDocs[lookupKey(f.id)]
+		dstDoc, dstOK := dstDocs[lookupKey(f.id)]
+		converged[i] = srcOK == dstOK && (!srcOK || cmp.equal(srcDoc, dstDoc))
+	}
+	return converged, nil
+}
+
+// findByIDs 使用 {_id: {$in: [...]}} 读取一批文档, 返回按 lookupKey 索引的文档
+func findByIDs(coll *mongo.Collection, cmp *comparator, ids bson.A) (map[string]bson.Raw, error) {
+	findOptions := options.FindOptions{
+		Projection: cmp.findProjection(),
+	}
+	cursor, err := coll.Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}}, &findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	docs := make(map[string]bson.Raw, len(ids))
+	for cursor.Next(context.Background()) {
+		docs[lookupKey(cursor.Current.Lookup("_id"))] = append(bson.Raw(nil), cursor.Current...)
+	}
+	return docs, cursor.Err()
+}
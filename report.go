@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// 退出码
+const (
+	exitOK       = 0 // 所有集合检查通过
+	exitError    = 1 // 参数错误、连接失败等导致无法完成检查, 和 log.Fatal 的退出码一致
+	exitMismatch = 2 // 检查完成, 存在超过策略允许范围的差异或者检查出错的集合
+	exitWarn     = 3 // 检查完成, 存在差异但都在策略允许的范围内
+)
+
+// runReport 是 report 参数输出的 JSON 报告
+type runReport struct {
+	StartTime   time.Time         `json:"startTime"`
+	EndTime     time.Time         `json:"endTime"`
+	Duration    float64           `json:"durationSeconds"`
+	Params      map[string]string `json:"params"`
+	Versions    clusterVersions   `json:"versions"`
+	Snapshot    *snapshotReport   `json:"snapshot,omitempty"`   // 开启 snapshot 时源集群快照读的时间点
+	Namespaces  *inventoryDiff    `json:"namespaces,omitempty"` // 开启 checkNamespaces 时两边命名空间清单的差异
+	Collections []collReport      `json:"collections"`
+	Totals      map[string]int64  `json:"totals"`
+	Verdict     string            `json:"verdict"` // pass|warn|fail
+	ExitCode    int               `json:"exitCode"`
+}
+
+// snapshotReport 是源集群快照读的时间点, fallback 表示因为快照历史不足回退为普通读, 受影响的集合见 collReport.SnapshotFallback
+type snapshotReport struct {
+	AtClusterTime primitive.Timestamp `json:"atClusterTime"`
+	Fallback      bool                `json:"fallback"`
+}
+
+// clusterVersions 是两边集群的版本号
+type clusterVersions struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// collReport 是单个集合的检查结果
+type collReport struct {
+	NS                string           `json:"ns"`
+	DstNS             string           `json:"dstNs,omitempty"` // 按 nsMap 映射后的目标命名空间
+	Mode              string           `json:"mode"`            // 实际使用的比对方式, 例如 collscan、partitions、skip,skip(reverse), 没有比对数据时为 none
+	SampleSize        int64            `json:"sampleSize"`      // 计划比对的文档数, 抽样时为抽样条数, 全量比对时为文档数
+	Verdict           string           `json:"verdict"`         // pass|warn|fail
+	Reasons           []string         `json:"reasons"`         // 结论不是 pass 的原因
+	Compared          int64            `json:"compared"`        // 比对过的文档数, 包括一致和不一致的文档
+	Same              int64            `json:"same"`
+	Findings          map[string]int64 `json:"findings"`
+	Duration          float64          `json:"durationSeconds"`
+	Throughput        float64          `json:"docsPerSecond"`
+	TopPaths          []pathReport     `json:"topPaths"`
+	Mismatches        []findingReport  `json:"mismatches"`
+	MismatchesOmitted int64            `json:"mismatchesOmitted"`   // 超过报告上限没有列出的不一致数
+	Converged         *convergedReport `json:"converged,omitempty"` // 开启复查时, 复查后一致的不一致
+	Indexes           *indexReport     `json:"indexes,omitempty"`
+	Errors            []string         `json:"errors"`
+	SnapshotFallback  bool             `json:"snapshotFallback,omitempty"` // 开启 snapshot 时, 比对期间快照读回退为普通读, 结果不是同一时间点的快照
+}
+
+type pathReport struct {
+	Path  string `json:"path"`
+	Count int64  `json:"count"`
+}
+
+// findingReport 是一条不一致的明细, 内容不一致时附带 JSON Patch
+type findingReport struct {
+	Kind  string          `json:"kind"`
+	ID    json.RawMessage `json:"_id"`
+	Patch []diffOp        `json:"patch,omitempty"`
+}
+
+// convergedReport 是复查后已经一致的不一致, 通常是同步延迟导致的
+type convergedReport struct {
+	Count      int64                 `json:"count"`
+	MaxSeconds float64               `json:"maxSeconds"` // 从发现到复查一致经过的最长时间
+	Details    []convergedItemReport `json:"details"`    // 最多 1000 条
+}
+
+type convergedItemReport struct {
+	Kind     string          `json:"kind"`
+	ID       json.RawMessage `json:"_id"`
+	Attempts int             `json:"attempts"` // 第几次复查时一致
+	Seconds  float64         `json:"seconds"`  // 从发现到复查一致经过的时间
+}
+
+// indexReport 是索引比对的结果, 索引按原始 BSON 排序后逐个比较
+type indexReport struct {
+	Equal bool              `json:"equal"`
+	Src   []json.RawMessage `json:"src"`
+	Dst   []json.RawMessage `json:"dst"`
+}
+
+// uriPassword 匹配连接串中的密码
+var uriPassword = regexp.MustCompile(`://([^:/@]*):[^@/]*@`)
+
+// buildReport 根据所有集合的检查结果和命名空间清单的差异生成报告, 连接串中的密码会被隐藏
+func buildReport(start time.Time, versions clusterVersions, results []*collResult, inventory *inventoryDiff) *runReport {
+	report := &runReport{
+		StartTime: start,
+		EndTime:   time.Now(),
+		Params:    make(map[string]string),
+		Versions:  versions,
+		Totals:    make(map[string]int64),
+		Verdict:   verdictKeys[verdictPass],
+		ExitCode:  exitOK,
+	}
+	report.Duration = report.EndTime.Sub(start).Seconds()
+	if srcSnapshot != nil {
+		report.Snapshot = &snapshotReport{AtClusterTime: srcSnapshot.time, Fallback: srcSnapshot.disabled.Load()}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		report.Params[f.Name] = uriPassword.ReplaceAllString(f.Value.String(), "://$1:******@")
+	})
+
+	report.Totals["same"] = 0
+	for _, key := range findingKeys {
+		report.Totals[key] = 0
+	}
+	for _, r := range results {
+		coll := r.report()
+		report.Collections = append(report.Collections, coll)
+		report.Totals["same"] += coll.Same
+		for key, n := range coll.Findings {
+			report.Totals[key] += n
+		}
+		switch {
+		case coll.Verdict == verdictKeys[verdictFail]:
+			report.Verdict = coll.Verdict
+			report.ExitCode = exitMismatch
+		case coll.Verdict == verdictKeys[verdictWarn] && report.ExitCode == exitOK:
+			report.Verdict = coll.Verdict
+			report.ExitCode = exitWarn
+		}
+	}
+	if inventory != nil {
+		report.Namespaces = inventory
+		if !inventory.empty() {
+			report.Verdict = verdictKeys[verdictFail]
+			report.ExitCode = exitMismatch
+		}
+	}
+	return report
+}
+
+// report 将集合的检查结果转换成报告中的格式
+func (r *collResult) report() collReport {
+	coll := collReport{
+		NS:       r.ns,
+		DstNS:    r.dstNS,
+		Same:     r.same.Load(),
+		Compared: r.compared(),
+		Findings: make(map[string]int64, findingKinds),
+		Duration: r.end.Sub(r.start).Seconds(),
+		Reasons:  []string{},
+		Errors:   []string{},
+	}
+	v, reasons := r.evaluate()
+	coll.Verdict = verdictKeys[v]
+	coll.Reasons = append(coll.Reasons, reasons...)
+	for i, key := range findingKeys {
+		coll.Findings[key] = r.findings[i].Load()
+	}
+	if coll.Duration > 0 {
+		coll.Throughput = float64(coll.Compared) / coll.Duration
+	}
+	for _, p := range r.topPaths(*topPaths) {
+		coll.TopPaths = append(coll.TopPaths, pathReport{Path: p.path, Count: p.count})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	coll.Mode = "none"
+	if len(r.strategies) > 0 {
+		coll.Mode = strings.Join(r.strategies, ",")
+	}
+	coll.SampleSize = r.planned
+	coll.SnapshotFallback = r.snapshotFallback
+	coll.Mismatches = make([]findingReport, 0, len(r.details))
+	for _, f := range r.details {
+		id, _ := extJSON(f.id, 0)
+		coll.Mismatches = append(coll.Mismatches, findingReport{Kind: findingKeys[f.kind], ID: id, Patch: f.ops})
+	}
+	coll.MismatchesOmitted = r.dropped
+	if r.rechecked {
+		coll.Converged = &convergedReport{Count: r.convCount, MaxSeconds: r.convMax.Seconds(), Details: []convergedItemReport{}}
+		for _, c := range r.converged {
+			id, _ := extJSON(c.id, 0)
+			coll.Converged.Details = append(coll.Converged.Details, convergedItemReport{
+				Kind: findingKeys[c.kind], ID: id, Attempts: c.attempts, Seconds: c.elapsed.Seconds()})
+		}
+	}
+	for _, err := range r.errs {
+		coll.Errors = append(coll.Errors, err.Error())
+	}
+	if r.indexes != nil {
+		coll.Indexes = &indexReport{Equal: r.indexes.equal}
+		for _, index := range r.indexes.src {
+			data, _ := bson.MarshalExtJSON(index, false, false)
+			coll.Indexes.Src = append(coll.Indexes.Src, data)
+		}
+		for _, index := range r.indexes.dst {
+			data, _ := bson.MarshalExtJSON(index, false, false)
+			coll.Indexes.Dst = append(coll.Indexes.Dst, data)
+		}
+	}
+	return coll
+}
+
+// writeReport 按 format 将报告写入文件, 可选 json|junit|html
+func writeReport(path string, format string, report *runReport) error {
+	var data []byte
+	var err error
+	switch format {
+	case "junit":
+		data, err = junitReport(report)
+	case "html":
+		data, err = htmlReport(report)
+	default:
+		data, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return fmt.Errorf("生成报告失败: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("写入报告 %s 失败: %v", path, err)
+	}
+	return nil
+}
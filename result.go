@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// findingKind 是发现的不一致的类别
+type findingKind int
+
+const (
+	findingMissing     findingKind = iota // 目标集群缺失的文档
+	findingExtra                          // 目标集群多余的文档
+	findingDiffer                         // 两边都存在但内容不一致的文档
+	findingTypeOnly                       // 只有字段类型不同, 值相同, 例如 int32(1) 和 double(1.0)
+	findingFieldOrder                     // 字段内容相同, 只有字段顺序不同
+	findingLookupError                    // 到查询一侧批量查询失败, 无法比对的文档
+	findingModified                       // 检查期间源集群修改过, 复查后仍然不一致的文档, 开启 watchChanges 时才有
+	findingKinds
+)
+
+var findingNames = [findingKinds]string{"目标集群缺失", "目标集群多余", "内容不一致", "仅类型不一致", "仅字段顺序不一致", "查询失败", "检查期间被修改"}
+
+// findingKeys 是报告中使用的类别名
+var findingKeys = [findingKinds]string{"missing", "extra", "differ", "typeOnly", "fieldOrder", "lookupError", "modified"}
+
+// 每个集合在报告中最多保留的不一致明细数
+const maxReportedFindings = 1000
+
+// 开启复查时每个集合最多等待复查的不一致数, 超过的不一致不复查, 直接作为最终结果
+const maxPendingFindings = 10 * maxReportedFindings
+
+// finding 是一条不一致的明细
+type finding struct {
+	kind  findingKind
+	id    bson.RawValue
+	ops   []diffOp
+	found time.Time
+}
+
+// collResult 记录单个集合的检查结果, 多个分区并发比对时共享同一个结果, 所以计数使用原子操作
+type collResult struct {
+	ns       string
+	dstNS    string // 按 nsMap 映射后的目标命名空间, 目标集合不存在时为空
+	same     atomic.Int64
+	findings [findingKinds]atomic.Int64
+
+	conf    collConfig // 集合的配置, 包括判定检查结果的策略
+	start   time.Time
+	end     time.Time
+	indexes *indexComparison // 没有比对索引时为 nil
+	counts  *countComparison // 没有比对文档数时为 nil
+
+	strategies []string // 实际使用的比对方式, 反向抽样带 (reverse) 后缀
+	planned    int64    // 计划比对的文档数, 抽样时为抽样条数, 全量比对时为文档数, 两个方向之和
+
+	snapshotFallback bool // 开启 snapshot 时, 比对期间快照读回退为普通读, 部分或者全部数据不是从快照读取的
+
+	mu      sync.Mutex
+	errs    []error          // 索引、文档数比对失败或者导致检查中断的错误
+	paths   map[string]int64 // 每个字段路径出现差异的文档数, 数组下标统一记为 *
+	details []finding        // 最多保留 maxReportedFindings 条
+	dropped int64            // 超过 maxReportedFindings 没有保留的明细数
+
+	pending   []finding          // 开启复查时记录的不一致, 等待复查, 最多 maxPendingFindings 条
+	unchecked int64              // 超过 maxPendingFindings 没有复查的不一致数
+	rechecked bool               // 已经开始复查, 此后记录的不一致不再复查
+	converged []convergedFinding // 复查时已经一致的不一致, 最多保留 maxReportedFindings 条
+	convCount int64              // 复查时已经一致的不一致总数
+	convMax   time.Duration      // 复查时已经一致的不一致中, 从发现到一致经过的最长时间
+}
+
+// newCollResult 创建源集合 srcColl 的检查结果, 目标集合不存在时 dstColl 为 nil
+func newCollResult(srcColl *mongo.Collection, dstColl *mongo.Collection) *collResult {
+	r := &collResult{
+		ns:    collNS(srcColl),
+		conf:  collectionConfig(srcColl.Database().Name(), srcColl.Name()),
+		start: time.Now(),
+		paths: make(map[string]int64),
+	}
+	if dstColl != nil {
+		r.dstNS = collNS(dstColl)
+	}
+	return r
+}
+
+// collNS 返回集合的 "库名.集合名", 同时检查多个库时日志中同名的集合可以区分开
+func collNS(coll *mongo.Collection) string {
+	return coll.Database().Name() + "." + coll.Name()
+}
+
+// recordMismatch 按差异对内容不一致的文档分类后记录, 同时统计出现差异的字段路径
+func (r *collResult) recordMismatch(id bson.RawValue, ops []diffOp) error {
+	kind := findingTypeOnly
+	if len(ops) == 0 {
+		kind = findingFieldOrder
+	}
+	for _, op := range ops {
+		if !op.typeOnly {
+			kind = findingDiffer
+		}
+	}
+	r.mu.Lock()
+	for path := range mismatchPaths(ops) {
+		r.paths[path]++
+	}
+	r.mu.Unlock()
+	return r.record(finding{kind: kind, id: id, ops: ops})
+}
+
+// mismatchPaths 返回一条不一致文档中出现差异的字段路径
+func mismatchPaths(ops []diffOp) map[string]bool {
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		seen[op.pattern] = true
+	}
+	return seen
+}
+
+// record 记录一处不一致, 不一致的总数达到 maxMismatches 时返回错误, 放弃检查该集合
+func (r *collResult) record(f finding) error {
+	r.findings[f.kind].Add(1)
+	// _id 可能引用游标的缓冲区, 需要拷贝
+	f.id = bson.RawValue{Type: f.id.Type, Value: append([]byte(nil), f.id.Value...)}
+	if f.found.IsZero() {
+		f.found = time.Now()
+	}
+	r.mu.Lock()
+	if len(r.details) < maxReportedFindings {
+		r.details = append(r.details, f)
+	} else {
+		r.dropped++
+	}
+	if recheckEnabled() && !r.rechecked {
+		if len(r.pending) < maxPendingFindings {
+			r.pending = append(r.pending, f)
+		} else {
+			r.unchecked++
+		}
+	}
+	r.mu.Unlock()
+	if n := r.total(); *maxMismatches > 0 && n >= int64(*maxMismatches) {
+		return fmt.Errorf("集合 %s 发现 %d 处不一致, 达到 maxMismatches, 放弃检查", r.ns, n)
+	}
+	return nil
+}
+
+// takePending 取出等待复查的不一致并从已经记录的不一致中去掉, 复查后仍然不一致的通过 restore 重新记录
+// 超过 maxPendingFindings 没有复查的不一致保留在计数中, 明细只保留前 maxReportedFindings 条, 都在等待复查的不一致中
+func (r *collResult) takePending() []finding {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := r.pending
+	r.pending = nil
+	r.rechecked = true
+	for _, f := range pending {
+		r.findings[f.kind].Add(-1)
+		for path := range mismatchPaths(f.ops) {
+			if r.paths[path]--; r.paths[path] <= 0 {
+				delete(r.paths, path)
+			}
+		}
+	}
+	r.details = nil
+	r.dropped = r.unchecked
+	if r.unchecked > 0 {
+		log.Printf("集合 %s 不一致超过 %d 处, 其余 %d 处不复查, 直接作为最终结果", r.ns, maxPendingFindings, r.unchecked)
+	}
+	return pending
+}
+
+// reset 清空已经比对的结果, 用于快照读失败后重新比对, 索引和文档数的比对结果保留
+func (r *collResult) reset() {
+	r.same.Store(0)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.findings {
+		r.findings[i].Store(0)
+	}
+	r.paths = make(map[string]int64)
+	r.details = nil
+	r.dropped = 0
+	r.pending = nil
+	r.unchecked = 0
+	r.rechecked = false
+	r.strategies = nil
+	r.planned = 0
+}
+
+// restore 重新记录复查后仍然不一致的文档, 保留第一次发现时的类别和差异
+func (r *collResult) restore(f finding) {
+	switch f.kind {
+	case findingDiffer, findingTypeOnly, findingFieldOrder:
+		r.recordMismatch(f.id, f.ops)
+	default:
+		r.record(f)
+	}
+}
+
+// converge 记录一处复查时已经一致的不一致
+func (r *collResult) converge(f finding, attempts int) {
+	elapsed := time.Since(f.found)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.convCount++
+	if elapsed > r.convMax {
+		r.convMax = elapsed
+	}
+	if len(r.converged) < maxReportedFindings {
+		r.converged = append(r.converged, convergedFinding{kind: f.kind, id: f.id, attempts: attempts, elapsed: elapsed})
+	}
+}
+
+// plan 记录实际使用的比对方式和计划比对的文档数
+func (r *collResult) plan(strategy string, dir sampleDirection, size int64) {
+	if dir == reverse {
+		strategy += "(reverse)"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.strategies = append(r.strategies, strategy)
+	r.planned += size
+}
+
+// addError 记录一个错误
+func (r *collResult) addError(err error) {
+	log.Printf("集合 %s 检查出错: %v", r.ns, err)
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+}
+
+// total 返回不一致的总数
+func (r *collResult) total() int64 {
+	n := int64(0)
+	for i := range r.findings {
+		n += r.findings[i].Load()
+	}
+	return n
+}
+
+// contentDiffers 返回两边都存在但内容不一致的文档数, 包括只有类型或者字段顺序不同的文档
+func (r *collResult) contentDiffers() int64 {
+	return r.findings[findingDiffer].Load() + r.findings[findingTypeOnly].Load() + r.findings[findingFieldOrder].Load()
+}
+
+// compared 返回比对过的文档数, 包括一致、复查后一致和不一致的文档, 不包括查询失败的文档
+func (r *collResult) compared() int64 {
+	r.mu.Lock()
+	converged := r.convCount
+	r.mu.Unlock()
+	return r.same.Load() + converged + r.total() - r.findings[findingLookupError].Load()
+}
+
+// topPaths 返回出现差异的文档数最多的 n 个字段路径
+func (r *collResult) topPaths(n int) []pathCount {
+	r.mu.Lock()
+	counts := make([]pathCount, 0, len(r.paths))
+	for path, count := range r.paths {
+		counts = append(counts, pathCount{path: path, count: count})
+	}
+	r.mu.Unlock()
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].count != counts[j].count {
+			return counts[i].count > counts[j].count
+		}
+		return counts[i].path < counts[j].path
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// pathCount 是一个字段路径以及出现差异的文档数
+type pathCount struct {
+	path  string
+	count int64
+}
+
+// String 返回一行检查结果, 用于汇总日志
+func (r *collResult) String() string {
+	v, reasons := r.evaluate()
+	name := r.ns
+	if r.dstNS != "" && r.dstNS != r.ns {
+		name = fmt.Sprintf("%s(目标 %s)", r.ns, r.dstNS)
+	}
+	parts := []string{fmt.Sprintf("集合 %s %s, 一致 %d 条", name, verdictNames[v], r.same.Load())}
+	for i, name := range findingNames {
+		parts = append(parts, fmt.Sprintf("%s %d 条", name, r.findings[i].Load()))
+	}
+	if r.rechecked {
+		parts = append(parts, fmt.Sprintf("复查后一致 %d 条(最长 %v)", r.convCount, r.convMax.Round(time.Millisecond)))
+	}
+	s := strings.Join(parts, ", ")
+	if top := r.topPaths(*topPaths); len(top) > 0 {
+		items := make([]string, 0, len(top))
+		for _, p := range top {
+			items = append(items, fmt.Sprintf("%s(%d)", p.path, p.count))
+		}
+		s += "\n    差异最多的字段: " + strings.Join(items, ", ")
+	}
+	if r.snapshotFallback {
+		s += "\n    警告: 比对期间快照读回退为普通读, 结果不是同一时间点的快照"
+	}
+	for _, reason := range reasons {
+		s += "\n    " + reason
+	}
+	for _, err := range r.errs {
+		s += fmt.Sprintf("\n    错误: %v", err)
+	}
+	return s
+}
+
+// logSummary 打印所有集合的检查结果, 返回检查失败的集合名
+func logSummary(results []*collResult) []string {
+	var failed []string
+	var same int64
+	var totals [findingKinds]int64
+	lines := make([]string, 0, len(results)+1)
+	for _, r := range results {
+		lines = append(lines, r.String())
+		if r.failed() {
+			failed = append(failed, r.ns)
+		}
+		same += r.same.Load()
+		for i := range totals {
+			totals[i] += r.findings[i].Load()
+		}
+	}
+	parts := []string{fmt.Sprintf("合计: 一致 %d 条", same)}
+	for i, name := range findingNames {
+		parts = append(parts, fmt.Sprintf("%s %d 条", name, totals[i]))
+	}
+	lines = append(lines, strings.Join(parts, ", "))
+	log.Printf("检查结果汇总, 共 %d 个集合, 失败 %d 个:\n%s", len(results), len(failed), strings.Join(lines, "\n"))
+	return failed
+}
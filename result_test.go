@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testClient 返回一个不会实际连接服务端的客户端, 只用于构造 *mongo.Collection
+func testClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+func TestNewCollResultDstNS(t *testing.T) {
+	client := testClient(t)
+	tests := []struct {
+		name      string
+		dst       *mongo.Collection
+		wantDstNS string
+		mapped    bool
+	}{
+		{name: "unmapped", dst: client.Database("db1").Collection("users"), wantDstNS: "db1.users"},
+		{name: "mapped", dst: client.Database("core").Collection("accounts"), wantDstNS: "core.accounts", mapped: true},
+		{name: "missing destination", dst: nil, wantDstNS: ""},
+	}
+	for _, tt := range tests {
+		r := newCollResult(client.Database("db1").Collection("users"), tt.dst)
+		if r.ns != "db1.users" || r.dstNS != tt.wantDstNS {
+			t.Errorf("%s: ns = %q, dstNS = %q, want db1.users, %q", tt.name, r.ns, r.dstNS, tt.wantDstNS)
+		}
+		if got := strings.Contains(r.String(), "(目标 "); got != tt.mapped {
+			t.Errorf("%s: summary shows destination = %v, want %v: %s", tt.name, got, tt.mapped, r.String())
+		}
+	}
+}
+
+func TestSnapshotFallbackReport(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		r := testResult(10, nil)
+		r.snapshotFallback = fallback
+		if got := r.report().SnapshotFallback; got != fallback {
+			t.Errorf("report().SnapshotFallback = %v, want %v", got, fallback)
+		}
+		if got := strings.Contains(r.String(), "快照读回退为普通读"); got != fallback {
+			t.Errorf("summary shows snapshot fallback = %v, want %v: %s", got, fallback, r.String())
+		}
+	}
+}
+
+func TestTakePendingAndRestore(t *testing.T) {
+	setFlag(t, recheckAttempts, 1)
+	r := testResult(0, nil)
+	id := rawValue(t, int32(1))
+	r.record(finding{kind: findingMissing, id: id})
+	r.recordMismatch(id, []diffOp{{pattern: "a"}, {pattern: "b.*", typeOnly: true}})
+	r.recordMismatch(id, []diffOp{{pattern: "b.*", typeOnly: true}})
+	if r.total() != 3 || len(r.pending) != 3 || r.paths["b.*"] != 2 {
+		t.Fatalf("before recheck: total = %d, pending = %d, paths = %v", r.total(), len(r.pending), r.paths)
+	}
+
+	pending := r.takePending()
+	if len(pending) != 3 || r.total() != 0 || len(r.paths) != 0 || len(r.details) != 0 || r.dropped != 0 {
+		t.Fatalf("after takePending: pending = %d, total = %d, paths = %v, details = %d, dropped = %d",
+			len(pending), r.total(), r.paths, len(r.details), r.dropped)
+	}
+	wantKinds := []findingKind{findingMissing, findingDiffer, findingTypeOnly}
+	for i, f := range pending {
+		if f.kind != wantKinds[i] {
+			t.Errorf("pending[%d].kind = %s, want %s", i, findingKeys[f.kind], findingKeys[wantKinds[i]])
+		}
+	}
+
+	// 复查后仍然不一致的按第一次发现时的类别和差异重新记录, 不再等待复查
+	r.restore(pending[1])
+	r.restore(pending[0])
+	if r.findings[findingDiffer].Load() != 1 || r.findings[findingMissing].Load() != 1 || r.total() != 2 {
+		t.Errorf("after restore: differ = %d, missing = %d, total = %d",
+			r.findings[findingDiffer].Load(), r.findings[findingMissing].Load(), r.total())
+	}
+	if r.paths["a"] != 1 || r.paths["b.*"] != 1 || len(r.details) != 2 || len(r.pending) != 0 {
+		t.Errorf("after restore: paths = %v, details = %d, pending = %d", r.paths, len(r.details), len(r.pending))
+	}
+}
+
+func TestTakePendingOverCap(t *testing.T) {
+	setFlag(t, recheckAttempts, 1)
+	r := testResult(0, nil)
+	id := rawValue(t, int32(1))
+	const extra = 5
+	for i := 0; i < maxPendingFindings+extra; i++ {
+		r.recordMismatch(id, []diffOp{{pattern: "a"}})
+	}
+	pending := r.takePending()
+	// 超过上限的不一致不复查, 直接作为最终结果, 明细已经都在等待复查的不一致中
+	if len(pending) != maxPendingFindings || r.total() != extra || r.paths["a"] != extra {
+		t.Errorf("pending = %d, total = %d, paths = %v, want %d, %d, %d", len(pending), r.total(), r.paths, maxPendingFindings, extra, extra)
+	}
+	if len(r.details) != 0 || r.dropped != extra {
+		t.Errorf("details = %d, dropped = %d, want 0, %d", len(r.details), r.dropped, extra)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// snapshotReader 是 snapshot 参数开启的源集群快照读, 所有源集群的数据读取都使用同一个 atClusterTime
+type snapshotReader struct {
+	client   *mongo.Client
+	time     primitive.Timestamp
+	disabled atomic.Bool // 快照历史不足时回退为普通读
+}
+
+// srcSnapshot 是源集群的快照读, 没有开启 snapshot 参数时为 nil
+var srcSnapshot *snapshotReader
+
+// startSnapshot 在 coll 上执行一次快照读, 由服务端选定之后所有读取使用的 atClusterTime
+func startSnapshot(coll *mongo.Collection) (*snapshotReader, error) {
+	client := coll.Database().Client()
+	sess, err := client.StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		return nil, fmt.Errorf("源集群开启快照会话失败: %v", err)
+	}
+	defer sess.EndSession(context.Background())
+
+	ctx := mongo.NewSessionContext(context.Background(), sess)
+	cursor, err := coll.Find(ctx, bson.D{}, options.Find().SetLimit(1).SetProjection(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("源集合 %s 快照读失败, 需要 5.0 及以上版本的副本集或者分片集群: %v", collNS(coll), err)
+	}
+	cursor.Close(ctx)
+	snapshotTime := sess.(mongo.XSession).ClientSession().SnapshotTime
+	if snapshotTime == nil {
+		return nil, fmt.Errorf("源集合 %s 快照读没有返回 atClusterTime", collNS(coll))
+	}
+	log.Printf("源集群开启快照读, atClusterTime:%v", *snapshotTime)
+	return &snapshotReader{client: client, time: *snapshotTime}, nil
+}
+
+// readContext 返回读取 coll 数据使用的 context, 开启快照读并且 coll 属于源集群时绑定一个快照会话
+// 会话不能在多个 goroutine 中同时使用, 所以每次读取单独创建会话并指定同一个 atClusterTime, 使用完需要调用返回的 end
+func readContext(ctx context.Context, coll *mongo.Collection) (context.Context, func()) {
+	s := srcSnapshot
+	if s == nil || s.disabled.Load() || coll.Database().Client() != s.client {
+		return ctx, func() {}
+	}
+	sess, err := s.client.StartSession(options.Session().SetSnapshot(true))
+	if err != nil {
+		log.Printf("源集群开启快照会话失败, 本次使用普通读: %v", err)
+		return ctx, func() {}
+	}
+	snapshotTime := s.time
+	sess.(mongo.XSession).ClientSession().SnapshotTime = &snapshotTime
+	return mongo.NewSessionContext(ctx, sess), func() { sess.EndSession(context.Background()) }
+}
+
+// fellBack 返回是否已经回退为普通读, 没有开启快照读时返回 false
+// 回退是全局的, 回退时正在比对的其他集合之后的读取也是普通读
+func (s *snapshotReader) fellBack() bool {
+	return s != nil && s.disabled.Load()
+}
+
+// fallback 判断 err 是否因为快照历史不足导致, 是则关闭快照读, 之后的读取回退为普通读
+// 错误在各处按 %v 包装过, 只能按服务端的错误名判断
+func (s *snapshotReader) fallback(err error) bool {
+	if s == nil || err == nil {
+		return false
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "(SnapshotTooOld)") && !strings.Contains(msg, "(SnapshotUnavailable)") {
+		return false
+	}
+	if s.disabled.CompareAndSwap(false, true) {
+		log.Printf("警告: 源集群快照 atClusterTime:%v 已经超出服务端保留的快照历史(minSnapshotHistoryWindowInSeconds, 默认 300 秒), "+
+			"回退为普通读, 之后的读取不再是同一个快照: %v", s.time, err)
+	}
+	return true
+}
+
+// clientOptions 返回连接集群的参数, readConcern 和 readPreference 不为空时覆盖连接串中的配置
+func clientOptions(uri string, level string, mode string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+	if level != "" {
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: level})
+	}
+	if mode != "" {
+		readMode, err := readpref.ModeFromString(mode)
+		if err != nil {
+			return nil, err
+		}
+		pref, err := readpref.New(readMode)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(pref)
+	}
+	return opts, nil
+}